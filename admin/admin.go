@@ -0,0 +1,238 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/thebluefowl/hookie/delivery"
+	"github.com/thebluefowl/hookie/metrics"
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/server"
+	"golang.org/x/exp/slog"
+)
+
+// DryRunHostHeader overrides the Host of a dry-run request, so that host
+// triggers can be exercised through the admin port.
+const DryRunHostHeader = "X-Hookie-Dry-Run-Host"
+
+// Admin exposes a read-only view of a running server on a separate listener.
+type Admin struct {
+	server  *server.Server
+	queue   model.PubSub
+	records delivery.Store
+	mux     *http.ServeMux
+	http    *http.Server
+}
+
+// New creates the admin handler for srv. queue is reported on /queue and is
+// considered connected unless it implements model.Pinger and the ping fails.
+// Delivery records are looked up in records on /status/{request-id}, which
+// answers 501 when records is nil.
+func New(srv *server.Server, queue model.PubSub, records delivery.Store) *Admin {
+	a := &Admin{
+		server:  srv,
+		queue:   queue,
+		records: records,
+		mux:     http.NewServeMux(),
+	}
+	a.mux.HandleFunc("/rules", a.rules)
+	a.mux.HandleFunc("/upstreams", a.upstreams)
+	a.mux.HandleFunc("/queue", a.queueStatus)
+	a.mux.HandleFunc("/stats", a.stats)
+	a.mux.HandleFunc("/dry-run/", a.dryRun)
+	a.mux.HandleFunc("/status/", a.status)
+	a.mux.Handle("/metrics", metrics.Handler())
+	a.http = &http.Server{Handler: a}
+	return a
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	a.mux.ServeHTTP(w, req)
+}
+
+// ListenAndServe starts the admin server on the given address. It returns
+// http.ErrServerClosed once Shutdown has been called.
+func (a *Admin) ListenAndServe(addr string) error {
+	a.http.Addr = addr
+	return a.http.ListenAndServe()
+}
+
+// Shutdown stops the admin server, waiting for active requests until ctx is
+// done.
+func (a *Admin) Shutdown(ctx context.Context) error {
+	return a.http.Shutdown(ctx)
+}
+
+func (a *Admin) rules(w http.ResponseWriter, req *http.Request) {
+	if !allowGet(w, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.Rules())
+}
+
+// Upstream is a delivery target along with the rules that use it.
+type Upstream struct {
+	URL           string
+	DeliveryModes []string
+	Rules         []string
+}
+
+func (a *Admin) upstreams(w http.ResponseWriter, req *http.Request) {
+	if !allowGet(w, req) {
+		return
+	}
+
+	byURL := map[string]*Upstream{}
+	for _, r := range a.server.Rules() {
+		primary, others := r.Action.Targets()
+		if m := r.Action.Mirrored(); m != nil {
+			others = append(others, m)
+		}
+		for _, t := range append([]*model.Action{primary}, others...) {
+			u, ok := byURL[t.UpstreamHost]
+			if !ok {
+				u = &Upstream{URL: t.UpstreamHost}
+				byURL[t.UpstreamHost] = u
+			}
+			u.DeliveryModes = appendUnique(u.DeliveryModes, t.DeliveryMode)
+			u.Rules = appendUnique(u.Rules, r.Name)
+		}
+	}
+
+	out := make([]*Upstream, 0, len(byURL))
+	for _, u := range byURL {
+		out = append(out, u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// QueueStatus reports the queue backend and whether it is reachable.
+type QueueStatus struct {
+	Backend   string
+	Connected bool
+	Error     string `json:",omitempty"`
+}
+
+func (a *Admin) queueStatus(w http.ResponseWriter, req *http.Request) {
+	if !allowGet(w, req) {
+		return
+	}
+
+	status := QueueStatus{Backend: fmt.Sprintf("%T", a.queue), Connected: a.queue != nil}
+	if p, ok := a.queue.(model.Pinger); ok {
+		if err := p.Ping(req.Context()); err != nil {
+			status.Connected = false
+			status.Error = err.Error()
+		}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *Admin) stats(w http.ResponseWriter, req *http.Request) {
+	if !allowGet(w, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.Stats())
+}
+
+// DryRun is the outcome of evaluating a request against the rules. Rules
+// lists every rule evaluated, in order, up to and including the match. Error
+// is the trigger error that stopped matching, which live traffic is answered
+// 502 for.
+type DryRun struct {
+	Matched *model.Rule `json:",omitempty"`
+	Error   string      `json:",omitempty"`
+	Rules   []RuleExplanation
+}
+
+type RuleExplanation struct {
+	Name        string
+	Explanation model.Explanation
+}
+
+// dryRun evaluates the request it receives, minus the /dry-run prefix, as if
+// it had arrived on the webhook port. Nothing is forwarded.
+func (a *Admin) dryRun(w http.ResponseWriter, req *http.Request) {
+	candidate := req.Clone(req.Context())
+	candidate.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/dry-run"), "/")
+	candidate.URL.RawPath = ""
+	if host := candidate.Header.Get(DryRunHostHeader); host != "" {
+		candidate.Host = host
+		candidate.Header.Del(DryRunHostHeader)
+	}
+
+	result := DryRun{}
+	for _, r := range a.server.Rules() {
+		result.Rules = append(result.Rules, RuleExplanation{Name: r.Name, Explanation: r.TriggerSet.Explain(candidate)})
+		matched, err := r.TriggerSet.Match(candidate)
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		if matched {
+			r := r
+			result.Matched = &r
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *Admin) status(w http.ResponseWriter, req *http.Request) {
+	if !allowGet(w, req) {
+		return
+	}
+	id := strings.TrimPrefix(req.URL.Path, "/status/")
+	if a.records == nil {
+		http.Error(w, "delivery records are not kept by this process", http.StatusNotImplemented)
+		return
+	}
+	if id == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	r, err := a.records.Get(req.Context(), id)
+	switch {
+	case errors.Is(err, delivery.ErrNotFound):
+		http.NotFound(w, req)
+	case err != nil:
+		slog.Error("failed to read delivery record", slog.String("request-id", id), slog.Any("err", err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, r)
+	}
+}
+
+func allowGet(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("failed to write admin response", slog.Any("err", err))
+	}
+}
+
+func appendUnique(s []string, v string) []string {
+	for _, x := range s {
+		if x == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
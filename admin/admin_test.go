@@ -0,0 +1,233 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/delivery"
+	"github.com/thebluefowl/hookie/forwarder"
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/server"
+	"gopkg.in/yaml.v2"
+)
+
+type fakeQueue struct {
+	err error
+}
+
+func (f *fakeQueue) Publish(ctx context.Context, payload []byte) error { return nil }
+
+func (f *fakeQueue) StartConsumer(ctx context.Context, processor func(body interface{}) error) error {
+	return nil
+}
+
+func (f *fakeQueue) Ping(ctx context.Context) error { return f.err }
+
+const rules = `
+- name: github
+  triggerset:
+    triggers:
+      - property: path
+        comparator: equal
+        value:
+          value: /hooks/github
+      - property: body
+        comparator: equal
+        value:
+          key: $.action
+          value: opened
+  action:
+    upstream: http://github-consumer
+    delivery_mode: queued
+- name: stripe
+  triggerset:
+    triggers:
+      - property: host
+        comparator: equal
+        value:
+          value: stripe.example.com
+  action:
+    delivery_mode: instant
+    upstreams:
+      - url: http://payments
+      - url: http://github-consumer
+        delivery_mode: queued
+`
+
+func newAdmin(t *testing.T, queue *fakeQueue) *Admin {
+	var r []model.Rule
+	require.NoError(t, yaml.Unmarshal([]byte(rules), &r))
+	srv := server.New(r, forwarder.NewInstantForwarder(nil, nil, nil), forwarder.NewQueuedForwarder(queue, nil))
+	return New(srv, queue, nil)
+}
+
+func get(t *testing.T, a *Admin, req *http.Request, v interface{}) int {
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	if v != nil {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+	return rec.Code
+}
+
+func TestAdmin_Rules(t *testing.T) {
+	a := newAdmin(t, &fakeQueue{})
+
+	var got []model.Rule
+	assert.Equal(t, http.StatusOK, get(t, a, httptest.NewRequest(http.MethodGet, "/rules", nil), &got))
+	require.Len(t, got, 2)
+	assert.Equal(t, "github", got[0].Name)
+	assert.Equal(t, model.PropertyBody, got[0].TriggerSet.Triggers[1].Property)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, get(t, a, httptest.NewRequest(http.MethodPost, "/rules", nil), nil))
+}
+
+func TestAdmin_Upstreams(t *testing.T) {
+	a := newAdmin(t, &fakeQueue{})
+
+	var got []Upstream
+	assert.Equal(t, http.StatusOK, get(t, a, httptest.NewRequest(http.MethodGet, "/upstreams", nil), &got))
+	assert.Equal(t, []Upstream{
+		{URL: "http://github-consumer", DeliveryModes: []string{model.DeliveryModeQueued}, Rules: []string{"github", "stripe"}},
+		{URL: "http://payments", DeliveryModes: []string{model.DeliveryModeInstant}, Rules: []string{"stripe"}},
+	}, got)
+}
+
+func TestAdmin_Queue(t *testing.T) {
+	var got QueueStatus
+	a := newAdmin(t, &fakeQueue{})
+	assert.Equal(t, http.StatusOK, get(t, a, httptest.NewRequest(http.MethodGet, "/queue", nil), &got))
+	assert.True(t, got.Connected)
+
+	a = newAdmin(t, &fakeQueue{err: errors.New("connection refused")})
+	assert.Equal(t, http.StatusOK, get(t, a, httptest.NewRequest(http.MethodGet, "/queue", nil), &got))
+	assert.False(t, got.Connected)
+	assert.Equal(t, "connection refused", got.Error)
+}
+
+func TestAdmin_DryRun(t *testing.T) {
+	a := newAdmin(t, &fakeQueue{})
+
+	var got DryRun
+	req := httptest.NewRequest(http.MethodPost, "/dry-run/hooks/github", strings.NewReader(`{"action":"opened"}`))
+	assert.Equal(t, http.StatusOK, get(t, a, req, &got))
+	require.NotNil(t, got.Matched)
+	assert.Equal(t, "github", got.Matched.Name)
+	assert.Len(t, got.Rules, 1)
+
+	got = DryRun{}
+	req = httptest.NewRequest(http.MethodPost, "/dry-run/hooks/github", strings.NewReader(`{"action":"closed"}`))
+	req.Header.Set(DryRunHostHeader, "stripe.example.com")
+	assert.Equal(t, http.StatusOK, get(t, a, req, &got))
+	require.NotNil(t, got.Matched)
+	assert.Equal(t, "stripe", got.Matched.Name)
+	require.Len(t, got.Rules, 2)
+	assert.False(t, got.Rules[0].Explanation.Matched)
+	assert.True(t, got.Rules[0].Explanation.Triggers[0].Matched)
+	assert.False(t, got.Rules[0].Explanation.Triggers[1].Matched)
+
+	got = DryRun{}
+	req = httptest.NewRequest(http.MethodGet, "/dry-run/unknown", nil)
+	assert.Equal(t, http.StatusOK, get(t, a, req, &got))
+	assert.Nil(t, got.Matched)
+	assert.Len(t, got.Rules, 2)
+}
+
+func TestAdmin_DryRunTriggerError(t *testing.T) {
+	var r []model.Rule
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- name: broken
+  triggerset:
+    triggers:
+      - property: cookie
+        comparator: equal
+        value:
+          value: session
+  action:
+    upstream: http://broken
+    delivery_mode: instant
+- name: catchall
+  triggerset:
+    operator: not
+    triggers:
+      - property: path
+        comparator: equal
+        value:
+          value: /never
+  action:
+    upstream: http://catchall
+    delivery_mode: instant
+`), &r))
+	queue := &fakeQueue{}
+	a := New(server.New(r, forwarder.NewInstantForwarder(nil, nil, nil), forwarder.NewQueuedForwarder(queue, nil)), queue, nil)
+
+	var got DryRun
+	req := httptest.NewRequest(http.MethodGet, "/dry-run/hooks", nil)
+	assert.Equal(t, http.StatusOK, get(t, a, req, &got))
+	assert.Nil(t, got.Matched)
+	assert.Contains(t, got.Error, model.ErrUnsupportedComparator.Error())
+	require.Len(t, got.Rules, 1)
+	assert.Equal(t, "broken", got.Rules[0].Name)
+}
+
+func TestAdmin_Stats(t *testing.T) {
+	var r []model.Rule
+	require.NoError(t, yaml.Unmarshal([]byte(rules), &r))
+	queue := &fakeQueue{}
+	srv := server.New(r, forwarder.NewInstantForwarder(nil, nil, nil), forwarder.NewQueuedForwarder(queue, nil))
+	a := New(srv, queue, nil)
+
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/hooks/github", strings.NewReader(`{"action":"opened"}`)))
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/nowhere", nil))
+
+	var got server.Stats
+	assert.Equal(t, http.StatusOK, get(t, a, httptest.NewRequest(http.MethodGet, "/stats", nil), &got))
+	assert.Equal(t, uint64(1), got.Unmatched)
+	assert.Equal(t, server.RuleStats{Matched: 1}, got.Rules["github"])
+}
+
+func TestAdmin_Status(t *testing.T) {
+	var r []model.Rule
+	require.NoError(t, yaml.Unmarshal([]byte(rules), &r))
+	queue := &fakeQueue{}
+	records := delivery.NewMemory(0)
+	srv := server.New(r, forwarder.NewInstantForwarder(nil, nil, records), forwarder.NewQueuedForwarder(queue, records))
+	srv.SetDeliveryStore(records)
+	a := New(srv, queue, records)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hooks/github", strings.NewReader(`{"action":"opened"}`)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	id := rec.Header().Get(server.RequestIDHeader)
+	require.NotEmpty(t, id)
+
+	var got delivery.Record
+	assert.Equal(t, http.StatusOK, get(t, a, httptest.NewRequest(http.MethodGet, "/status/"+id, nil), &got))
+	assert.Equal(t, id, got.RequestID)
+	assert.Equal(t, "github", got.Rule)
+	assert.Equal(t, delivery.StateQueued, got.State)
+	assert.NotNil(t, got.QueuedAt)
+
+	assert.Equal(t, http.StatusNotFound, get(t, a, httptest.NewRequest(http.MethodGet, "/status/unknown", nil), nil))
+
+	// Without records, unknown IDs are not confused with records being off.
+	assert.Equal(t, http.StatusNotImplemented, get(t, newAdmin(t, queue), httptest.NewRequest(http.MethodGet, "/status/"+id, nil), nil))
+}
+
+func TestAdmin_Metrics(t *testing.T) {
+	a := newAdmin(t, &fakeQueue{})
+	a.server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/nowhere", nil))
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "hookie_incoming_requests_total")
+	assert.Contains(t, rec.Body.String(), "hookie_rule_no_matches_total")
+}
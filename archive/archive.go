@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thebluefowl/hookie/proxyutils"
+)
+
+var ErrInvalidStatus = errors.New("invalid status filter")
+
+// Entry is one incoming request as received, along with the rule it matched,
+// if any, and the status it was answered with. ClientSubject is the subject
+// of the verified client certificate, which rules may have matched on.
+type Entry struct {
+	ReceivedAt    time.Time
+	Rule          string `json:",omitempty"`
+	Status        int
+	ClientSubject string `json:",omitempty"`
+	Request       *proxyutils.SerializableRequest
+}
+
+// RequestID returns the ID the request was assigned on arrival.
+func (e *Entry) RequestID() string {
+	return e.Request.ID
+}
+
+// Archive persists incoming requests so that they can be replayed later.
+type Archive interface {
+	Append(ctx context.Context, e *Entry) error
+	// Scan calls fn for each entry matching f, oldest first, and stops at the
+	// first error fn returns.
+	Scan(ctx context.Context, f Filter, fn func(e *Entry) error) error
+	Close() error
+}
+
+// Filter selects archived entries. Zero fields match everything; From is
+// inclusive and To exclusive.
+type Filter struct {
+	From       time.Time
+	To         time.Time
+	Rule       string
+	Status     StatusRange
+	RequestIDs []string
+}
+
+// Match reports whether e is selected by the filter.
+func (f *Filter) Match(e *Entry) bool {
+	if !f.From.IsZero() && e.ReceivedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.ReceivedAt.Before(f.To) {
+		return false
+	}
+	if f.Rule != "" && e.Rule != f.Rule {
+		return false
+	}
+	if !f.Status.Contains(e.Status) {
+		return false
+	}
+	if len(f.RequestIDs) == 0 {
+		return true
+	}
+	for _, id := range f.RequestIDs {
+		if id == e.RequestID() {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusRange selects statuses from Min up to but excluding Max. The zero
+// value selects every status.
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// ParseStatus parses a status code such as "502" or a class such as "5xx".
+func ParseStatus(s string) (StatusRange, error) {
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+		class := int(s[0]-'0') * 100
+		return StatusRange{Min: class, Max: class + 100}, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 100 || code > 599 {
+		return StatusRange{}, fmt.Errorf("%w: %q", ErrInvalidStatus, s)
+	}
+	return StatusRange{Min: code, Max: code + 1}, nil
+}
+
+func (r StatusRange) Contains(status int) bool {
+	if r == (StatusRange{}) {
+		return true
+	}
+	return status >= r.Min && status < r.Max
+}
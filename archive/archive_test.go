@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/proxyutils"
+)
+
+func newEntry(id, rule string, status int, at time.Time) *Entry {
+	return &Entry{
+		ReceivedAt: at,
+		Rule:       rule,
+		Status:     status,
+		Request: &proxyutils.SerializableRequest{
+			ID:     id,
+			Method: "POST",
+			URL:    "/hooks/" + rule,
+			Host:   "hooks.example.com",
+			Body:   []byte(`{"id":"` + id + `"}`),
+		},
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	r, err := ParseStatus("5xx")
+	require.NoError(t, err)
+	assert.Equal(t, StatusRange{Min: 500, Max: 600}, r)
+
+	r, err = ParseStatus("502")
+	require.NoError(t, err)
+	assert.Equal(t, StatusRange{Min: 502, Max: 503}, r)
+
+	for _, s := range []string{"", "6xx", "abc", "42", "1000"} {
+		_, err := ParseStatus(s)
+		assert.ErrorIs(t, err, ErrInvalidStatus, s)
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	e := newEntry("req-1", "stripe", 502, t0)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty", want: true},
+		{name: "from inclusive", filter: Filter{From: t0}, want: true},
+		{name: "before from", filter: Filter{From: t0.Add(time.Second)}},
+		{name: "to exclusive", filter: Filter{To: t0}},
+		{name: "rule", filter: Filter{Rule: "stripe"}, want: true},
+		{name: "other rule", filter: Filter{Rule: "github"}},
+		{name: "status class", filter: Filter{Status: StatusRange{Min: 500, Max: 600}}, want: true},
+		{name: "other status", filter: Filter{Status: StatusRange{Min: 200, Max: 300}}},
+		{name: "request id", filter: Filter{RequestIDs: []string{"req-0", "req-1"}}, want: true},
+		{name: "other request id", filter: Filter{RequestIDs: []string{"req-2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(e))
+		})
+	}
+}
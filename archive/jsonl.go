@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// DefaultMaxSize is the size at which a JSONL file is rotated.
+const DefaultMaxSize = 100 << 20
+
+const (
+	jsonlPrefix     = "requests-"
+	jsonlSuffix     = ".jsonl"
+	jsonlDateLayout = "2006-01-02"
+)
+
+// JSONL appends entries, one JSON object per line, to files in a directory.
+// A new file is started each UTC day and whenever the current one reaches
+// its maximum size, so old files can be compressed or removed independently.
+type JSONL struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	name jsonlName
+	size int64
+}
+
+// jsonlName identifies an archive file as requests-<date>.<seq>.jsonl.
+type jsonlName struct {
+	date string
+	seq  int
+}
+
+func (n jsonlName) String() string {
+	return fmt.Sprintf("%s%s.%03d%s", jsonlPrefix, n.date, n.seq, jsonlSuffix)
+}
+
+func parseJSONLName(name string) (jsonlName, bool) {
+	var n jsonlName
+	rest, ok := strings.CutPrefix(name, jsonlPrefix)
+	if !ok || len(rest) < len(jsonlDateLayout) {
+		return n, false
+	}
+	n.date, rest = rest[:len(jsonlDateLayout)], rest[len(jsonlDateLayout):]
+	if _, err := time.Parse(jsonlDateLayout, n.date); err != nil {
+		return n, false
+	}
+	seq, ok := strings.CutSuffix(strings.TrimPrefix(rest, "."), jsonlSuffix)
+	if !ok {
+		return n, false
+	}
+	var err error
+	if n.seq, err = strconv.Atoi(seq); err != nil || n.seq < 0 {
+		return n, false
+	}
+	return n, true
+}
+
+// NewJSONL archives to dir, creating it if needed. A maxSize of zero uses
+// DefaultMaxSize.
+func NewJSONL(dir string, maxSize int64) (*JSONL, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &JSONL{dir: dir, maxSize: maxSize}, nil
+}
+
+func (j *JSONL) Append(ctx context.Context, e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.rotate(e.ReceivedAt.UTC().Format(jsonlDateLayout), int64(len(line))); err != nil {
+		return err
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// rotate makes sure the open file is of the given date and has room for
+// another line. Must be called with mu held.
+func (j *JSONL) rotate(date string, size int64) error {
+	if j.file != nil && j.name.date == date && (j.size == 0 || j.size+size <= j.maxSize) {
+		return nil
+	}
+
+	next := jsonlName{date: date}
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+		j.file = nil
+	}
+	if j.name.date == date {
+		next.seq = j.name.seq + 1
+	} else {
+		// Carry on with the latest file of the day, e.g. one left by a
+		// previous run.
+		names, err := j.names()
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			if n.date == date {
+				next = n
+			}
+		}
+	}
+
+	for {
+		f, err := os.OpenFile(filepath.Join(j.dir, next.String()), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open archive file: %w", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if info.Size() == 0 || info.Size()+size <= j.maxSize {
+			j.file, j.name, j.size = f, next, info.Size()
+			return nil
+		}
+		f.Close()
+		next.seq++
+	}
+}
+
+// names lists the archive files in dir, oldest first.
+func (j *JSONL) names() ([]jsonlName, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []jsonlName
+	for _, e := range entries {
+		if n, ok := parseJSONLName(e.Name()); ok && !e.IsDir() {
+			names = append(names, n)
+		}
+	}
+	sort.Slice(names, func(a, b int) bool {
+		if names[a].date != names[b].date {
+			return names[a].date < names[b].date
+		}
+		return names[a].seq < names[b].seq
+	})
+	return names, nil
+}
+
+// Scan reads the files that may hold entries in the filter's time range.
+// Lines that cannot be decoded, e.g. one cut short by a crash, are skipped.
+func (j *JSONL) Scan(ctx context.Context, f Filter, fn func(e *Entry) error) error {
+	names, err := j.names()
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if !f.From.IsZero() && n.date < f.From.UTC().Format(jsonlDateLayout) {
+			continue
+		}
+		if !f.To.IsZero() && n.date > f.To.UTC().Format(jsonlDateLayout) {
+			continue
+		}
+		if err := j.scanFile(ctx, n.String(), f, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *JSONL) scanFile(ctx context.Context, name string, f Filter, fn func(e *Entry) error) error {
+	file, err := os.Open(filepath.Join(j.dir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			e := &Entry{}
+			if jerr := json.Unmarshal(line, e); jerr != nil || e.Request == nil {
+				slog.Warn("skipping unreadable archive entry", slog.String("file", name), slog.Int("line", lineNo), slog.Any("err", jerr))
+			} else if f.Match(e) {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (j *JSONL) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
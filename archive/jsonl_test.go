@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scanIDs(t *testing.T, a Archive, f Filter) []string {
+	var ids []string
+	require.NoError(t, a.Scan(context.Background(), f, func(e *Entry) error {
+		ids = append(ids, e.RequestID())
+		return nil
+	}))
+	return ids
+}
+
+func TestJSONL_Rotates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	day1 := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+
+	j, err := NewJSONL(dir, 1)
+	require.NoError(t, err)
+	require.NoError(t, j.Append(ctx, newEntry("req-1", "stripe", 200, day1)))
+	require.NoError(t, j.Append(ctx, newEntry("req-2", "stripe", 502, day1)))
+	require.NoError(t, j.Append(ctx, newEntry("req-3", "github", 202, day2)))
+	require.NoError(t, j.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	require.NoError(t, err)
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	assert.Equal(t, []string{
+		"requests-2024-03-01.000.jsonl",
+		"requests-2024-03-01.001.jsonl",
+		"requests-2024-03-02.000.jsonl",
+	}, files)
+
+	// A new instance carries on after the latest file of the day.
+	j, err = NewJSONL(dir, 1)
+	require.NoError(t, err)
+	defer j.Close()
+	require.NoError(t, j.Append(ctx, newEntry("req-4", "github", 202, day2)))
+	_, err = os.Stat(filepath.Join(dir, "requests-2024-03-02.001.jsonl"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"req-1", "req-2", "req-3", "req-4"}, scanIDs(t, j, Filter{}))
+	assert.Equal(t, []string{"req-3", "req-4"}, scanIDs(t, j, Filter{From: day1.Add(time.Hour)}))
+	assert.Equal(t, []string{"req-2"}, scanIDs(t, j, Filter{Status: StatusRange{Min: 500, Max: 600}}))
+}
+
+func TestJSONL_ScanSkipsUnreadableLines(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	j, err := NewJSONL(dir, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	require.NoError(t, j.Append(ctx, newEntry("req-1", "stripe", 200, t0)))
+
+	f, err := os.OpenFile(filepath.Join(dir, "requests-2024-03-01.000.jsonl"), os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"ReceivedAt":`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, []string{"req-1"}, scanIDs(t, j, Filter{}))
+}
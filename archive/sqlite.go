@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	// Registers the sqlite driver, which needs no cgo.
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS requests (
+	request_id  TEXT    NOT NULL,
+	received_at INTEGER NOT NULL,
+	rule        TEXT    NOT NULL,
+	status      INTEGER NOT NULL,
+	entry       BLOB    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS requests_received_at ON requests (received_at);
+CREATE INDEX IF NOT EXISTS requests_request_id ON requests (request_id);
+`
+
+// SQLite archives entries in an embedded database file, indexed by time and
+// request ID. It can be read while the server is writing to it.
+type SQLite struct {
+	db *sql.DB
+}
+
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite archive: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite archive schema: %w", err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+func (s *SQLite) Append(ctx context.Context, e *Entry) error {
+	entry, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO requests (request_id, received_at, rule, status, entry) VALUES (?, ?, ?, ?, ?)",
+		e.RequestID(), e.ReceivedAt.UnixNano(), e.Rule, e.Status, entry)
+	return err
+}
+
+func (s *SQLite) Scan(ctx context.Context, f Filter, fn func(e *Entry) error) error {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if !f.From.IsZero() {
+		where, args = append(where, "received_at >= ?"), append(args, f.From.UnixNano())
+	}
+	if !f.To.IsZero() {
+		where, args = append(where, "received_at < ?"), append(args, f.To.UnixNano())
+	}
+	if f.Rule != "" {
+		where, args = append(where, "rule = ?"), append(args, f.Rule)
+	}
+	if f.Status != (StatusRange{}) {
+		where, args = append(where, "status >= ? AND status < ?"), append(args, f.Status.Min, f.Status.Max)
+	}
+	if len(f.RequestIDs) > 0 {
+		where = append(where, "request_id IN (?"+strings.Repeat(", ?", len(f.RequestIDs)-1)+")")
+		for _, id := range f.RequestIDs {
+			args = append(args, id)
+		}
+	}
+
+	query := "SELECT entry FROM requests"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY received_at, rowid"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry []byte
+		if err := rows.Scan(&entry); err != nil {
+			return err
+		}
+		e := &Entry{}
+		if err := json.Unmarshal(entry, e); err != nil {
+			return fmt.Errorf("failed to decode archive entry: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,43 @@
+package archive
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	s, err := NewSQLite(filepath.Join(t.TempDir(), "archive.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	var mode string
+	require.NoError(t, s.db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode, "readable while written")
+
+	require.NoError(t, s.Append(ctx, newEntry("req-1", "stripe", 200, t0)))
+	require.NoError(t, s.Append(ctx, newEntry("req-2", "stripe", 502, t0.Add(time.Minute))))
+	require.NoError(t, s.Append(ctx, newEntry("req-3", "github", 503, t0.Add(2*time.Minute))))
+
+	assert.Equal(t, []string{"req-1", "req-2", "req-3"}, scanIDs(t, s, Filter{}))
+	assert.Equal(t, []string{"req-2"}, scanIDs(t, s, Filter{From: t0.Add(time.Minute), To: t0.Add(2 * time.Minute)}))
+	assert.Equal(t, []string{"req-2"}, scanIDs(t, s, Filter{Rule: "stripe", Status: StatusRange{Min: 500, Max: 600}}))
+	assert.Equal(t, []string{"req-1", "req-3"}, scanIDs(t, s, Filter{RequestIDs: []string{"req-3", "req-1"}}))
+
+	var got *Entry
+	require.NoError(t, s.Scan(ctx, Filter{RequestIDs: []string{"req-1"}}, func(e *Entry) error {
+		got = e
+		return nil
+	}))
+	require.NotNil(t, got)
+	assert.True(t, t0.Equal(got.ReceivedAt))
+	assert.Equal(t, `{"id":"req-1"}`, string(got.Request.Body))
+	assert.Equal(t, "hooks.example.com", got.Request.Host)
+}
@@ -0,0 +1,191 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/thebluefowl/hookie/metrics"
+	"golang.org/x/exp/slog"
+)
+
+// DefaultOpenFor is how long a breaker stays open when no duration is set.
+const DefaultOpenFor = 30 * time.Second
+
+// probeWait is how long callers are told to wait while a probe is in flight.
+const probeWait = time.Second
+
+var ErrOpen = errors.New("circuit breaker open")
+
+var now = time.Now
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Settings configure every breaker of a Set. A breaker opens after Failures
+// consecutive failures and lets a single probe through once OpenFor has
+// elapsed; the probe's outcome closes it or opens it again.
+type Settings struct {
+	Failures int
+	OpenFor  time.Duration
+}
+
+// Breaker tracks the health of one upstream. A nil Breaker always allows
+// requests.
+type Breaker struct {
+	name     string
+	settings Settings
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// Allow reports whether a request may be sent. When it returns true the
+// caller must report the outcome with Success or Failure.
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if now().Sub(b.openedAt) < b.settings.OpenFor {
+			return false
+		}
+		b.transition(HalfOpen)
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Wait returns how long until Allow may succeed, zero if it already can.
+func (b *Breaker) Wait() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == Open:
+		if d := b.settings.OpenFor - now().Sub(b.openedAt); d > 0 {
+			return d
+		}
+		return 0
+	case b.state == HalfOpen && b.probing:
+		return probeWait
+	default:
+		return 0
+	}
+}
+
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	if b.state != Closed {
+		b.transition(Closed)
+	}
+}
+
+func (b *Breaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.settings.Failures) {
+		b.openedAt = now()
+		b.transition(Open)
+	}
+}
+
+func (b *Breaker) State() State {
+	if b == nil {
+		return Closed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// transition must be called with mu held.
+func (b *Breaker) transition(to State) {
+	b.state = to
+	metrics.CircuitBreakerState.WithLabelValues(b.name).Set(float64(to))
+	slog.Info("CIRCUIT-STATE-CHANGE", slog.String("state", to.String()), slog.String("upstream", b.name), slog.Int("failures", b.failures))
+}
+
+// Set holds one breaker per upstream host. A nil Set disables circuit
+// breaking.
+type Set struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewSet returns the breakers for the given settings, nil when
+// settings.Failures is not positive.
+func NewSet(settings Settings) *Set {
+	if settings.Failures <= 0 {
+		return nil
+	}
+	if settings.OpenFor <= 0 {
+		settings.OpenFor = DefaultOpenFor
+	}
+	return &Set{settings: settings, breakers: make(map[string]*Breaker)}
+}
+
+// Get returns the breaker for host, creating it closed on first use.
+func (s *Set) Get(host string) *Breaker {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[host]
+	if !ok {
+		b = &Breaker{name: host, settings: s.settings}
+		s.breakers[host] = b
+	}
+	return b
+}
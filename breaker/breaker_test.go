@@ -0,0 +1,62 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	t0 := time.Unix(1700000000, 0)
+	now = func() time.Time { return t0 }
+
+	b := NewSet(Settings{Failures: 2, OpenFor: 10 * time.Second}).Get("upstream:80")
+
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, Closed, b.State())
+	b.Success()
+	b.Failure()
+	assert.Equal(t, Closed, b.State(), "successes reset the failure count")
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+	assert.Equal(t, 10*time.Second, b.Wait())
+
+	// Once open for long enough, a single probe is let through.
+	now = func() time.Time { return t0.Add(10 * time.Second) }
+	assert.Zero(t, b.Wait())
+	assert.True(t, b.Allow())
+	assert.Equal(t, HalfOpen, b.State())
+	assert.False(t, b.Allow())
+	assert.Equal(t, probeWait, b.Wait())
+
+	// A failed probe opens it again.
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+
+	now = func() time.Time { return t0.Add(20 * time.Second) }
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, Closed, b.State())
+	assert.True(t, b.Allow())
+}
+
+func TestSet(t *testing.T) {
+	assert.Nil(t, NewSet(Settings{}))
+
+	var disabled *Set
+	b := disabled.Get("upstream:80")
+	assert.Nil(t, b)
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, Closed, b.State())
+
+	s := NewSet(Settings{Failures: 1})
+	assert.Same(t, s.Get("a:80"), s.Get("a:80"))
+	assert.NotSame(t, s.Get("a:80"), s.Get("b:80"))
+	assert.Equal(t, DefaultOpenFor, s.Get("a:80").settings.OpenFor)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/thebluefowl/hookie/archive"
+)
+
+const (
+	archiveBackendJSONL  = "jsonl"
+	archiveBackendSQLite = "sqlite"
+)
+
+// initializeArchive opens the request archive, nil when it is not configured.
+func initializeArchive(config *Config) archive.Archive {
+	if config.Archive == nil {
+		return nil
+	}
+	a, err := openArchive(config.Archive)
+	handleErrorWithMessage(err, "failed to open archive")
+	return a
+}
+
+func openArchive(cfg *Archive) (archive.Archive, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("archive path not configured")
+	}
+	switch cfg.Backend {
+	case "", archiveBackendJSONL:
+		return archive.NewJSONL(cfg.Path, int64(cfg.MaxSize)<<20)
+	case archiveBackendSQLite:
+		return archive.NewSQLite(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown archive backend %q", cfg.Backend)
+	}
+}
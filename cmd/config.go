@@ -1,8 +1,86 @@
 package main
 
+import "github.com/thebluefowl/hookie/tlsconfig"
+
+// Config selects one queue backend; when several are set, rabbitmq takes
+// precedence over bolt, and bolt over memory.
+//
+// Port is where webhooks are received and /healthz and /readyz are answered;
+// a worker only answers the probes.
+//
+// AdminPort enables the admin API on a separate port when set.
+//
+// ShutdownTimeout bounds, in seconds, how long in-flight requests and
+// deliveries are waited for on SIGINT or SIGTERM; 0 uses the default.
+//
+// RulesPollInterval is how often, in seconds, the rules file is checked for
+// changes; 0 uses the default and a negative value only reloads on SIGHUP.
 type Config struct {
-	Port     int       `yaml:"port"`
-	RabbitMQ *RabbitMQ `yaml:"rabbitmq"`
+	Port              int             `yaml:"port"`
+	AdminPort         int             `yaml:"admin_port"`
+	TLS               *TLS            `yaml:"tls"`
+	RulesPollInterval int             `yaml:"rules_poll_interval"`
+	ShutdownTimeout   int             `yaml:"shutdown_timeout"`
+	CircuitBreaker    *CircuitBreaker `yaml:"circuit_breaker"`
+	Deliveries        *Deliveries     `yaml:"deliveries"`
+	Archive           *Archive        `yaml:"archive"`
+	RabbitMQ          *RabbitMQ       `yaml:"rabbitmq"`
+	Bolt              *Bolt           `yaml:"bolt"`
+	Memory            *Memory         `yaml:"memory"`
+}
+
+// CircuitBreaker opens the breaker of an upstream host after Failures
+// consecutive failed deliveries, and probes it again after OpenFor seconds.
+type CircuitBreaker struct {
+	Failures int `yaml:"failures"`
+	OpenFor  int `yaml:"open_for"`
+}
+
+// TLS serves webhooks over TLS with the certificate in CertFile and KeyFile,
+// reloaded when the files change, checked every PollInterval seconds and on
+// SIGHUP; 0 uses the default and a negative value only reloads on SIGHUP.
+// MinVersion is one of 1.0 to 1.3, 1.2 by default, and CipherSuites are Go
+// names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+//
+// With ClientCAFile, callers must present a certificate signed by the bundle,
+// whose subject rules can match with the client_subject property. ClientAuth
+// verify_if_given also accepts callers without one, such as probes.
+type TLS struct {
+	CertFile     string   `yaml:"cert_file"`
+	KeyFile      string   `yaml:"key_file"`
+	MinVersion   string   `yaml:"min_version"`
+	CipherSuites []string `yaml:"cipher_suites"`
+	ClientCAFile string   `yaml:"client_ca_file"`
+	ClientAuth   string   `yaml:"client_auth"`
+	PollInterval int      `yaml:"poll_interval"`
+}
+
+func (t *TLS) options() tlsconfig.Options {
+	return tlsconfig.Options{
+		CertFile:     t.CertFile,
+		KeyFile:      t.KeyFile,
+		MinVersion:   t.MinVersion,
+		CipherSuites: t.CipherSuites,
+		ClientCAFile: t.ClientCAFile,
+		ClientAuth:   t.ClientAuth,
+	}
+}
+
+// Deliveries keeps delivery records in a bbolt file at Path, distinct from the
+// bolt queue's, for Retention hours. Without it, recent records are kept in
+// memory.
+type Deliveries struct {
+	Path      string `yaml:"path"`
+	Retention int    `yaml:"retention"`
+}
+
+// Archive stores every incoming request for replay. The jsonl backend, the
+// default, writes to files in the Path directory rotated daily and every
+// MaxSize megabytes; the sqlite backend writes to the database file at Path.
+type Archive struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+	MaxSize int    `yaml:"max_size"`
 }
 
 type RabbitMQ struct {
@@ -13,4 +91,14 @@ type RabbitMQ struct {
 	Exchange   string `yaml:"exchange"`
 	RoutingKey string `yaml:"routing_key"`
 	Queue      string `yaml:"queue"`
+
+	DeadLetterExchange   string `yaml:"dead_letter_exchange"`
+	DeadLetterRoutingKey string `yaml:"dead_letter_routing_key"`
+	DeadLetterQueue      string `yaml:"dead_letter_queue"`
 }
+
+type Bolt struct {
+	Path string `yaml:"path"`
+}
+
+type Memory struct{}
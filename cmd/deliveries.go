@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/thebluefowl/hookie/delivery"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	defaultDeliveryRetention = 7 * 24 * time.Hour
+	deliveryPruneInterval    = time.Hour
+)
+
+// initializeDeliveryRecords opens the store of delivery records, in memory
+// unless a path is configured.
+func initializeDeliveryRecords(ctx context.Context, config *Config) delivery.Store {
+	if config.Deliveries == nil || config.Deliveries.Path == "" {
+		return delivery.NewMemory(delivery.DefaultCapacity)
+	}
+
+	store, err := delivery.NewBolt(config.Deliveries.Path)
+	handleErrorWithMessage(err, "failed to open delivery records")
+
+	retention := time.Duration(config.Deliveries.Retention) * time.Hour
+	if retention <= 0 {
+		retention = defaultDeliveryRetention
+	}
+	go pruneDeliveries(ctx, store, retention)
+	return store
+}
+
+// pruneDeliveries periodically drops the records older than retention.
+func pruneDeliveries(ctx context.Context, store *delivery.Bolt, retention time.Duration) {
+	ticker := time.NewTicker(deliveryPruneInterval)
+	defer ticker.Stop()
+	for {
+		n, err := store.Prune(ctx, time.Now().Add(-retention))
+		if err != nil {
+			slog.Error("failed to prune delivery records", slog.Any("err", err))
+		} else if n > 0 {
+			slog.Info("DELIVERIES-PRUNED", slog.Int("count", n))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/thebluefowl/hookie/health"
+	"github.com/thebluefowl/hookie/listener"
+	"github.com/thebluefowl/hookie/metrics"
+	"github.com/thebluefowl/hookie/model"
+)
+
+// addReadinessChecks makes the process unready while the queue is
+// unreachable, so that traffic is shifted away rather than queued deliveries
+// failing, and while consumer, if any, is not running.
+func addReadinessChecks(probes *health.Probes, queue model.PubSub, consumer *listener.Listener) {
+	if p, ok := queue.(model.Pinger); ok {
+		probes.Add("queue", p.Ping)
+	}
+	if consumer != nil {
+		probes.Add("consumer", func(ctx context.Context) error {
+			if !consumer.Running() {
+				return errors.New("consumer not running")
+			}
+			return nil
+		})
+	}
+}
+
+// initializeProbeServer serves the probes and metrics of a worker, which
+// receives no webhooks, on the webhook port.
+func initializeProbeServer(config *Config, queue model.PubSub, consumer *listener.Listener) []shutdowner {
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", config.Port), Handler: probeHandler(queue, consumer)}
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			handleErrorWithMessage(err, "failed to start probe server")
+		}
+	}()
+	return []shutdowner{srv}
+}
+
+func probeHandler(queue model.PubSub, consumer *listener.Listener) http.Handler {
+	probes := health.New()
+	addReadinessChecks(probes, queue, consumer)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", probes)
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebluefowl/hookie/health"
+	"github.com/thebluefowl/hookie/queue"
+)
+
+func TestProbeHandler(t *testing.T) {
+	handler := probeHandler(queue.NewMemory(), nil)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: health.LivenessPath, wantStatus: http.StatusOK, wantBody: "ok"},
+		{path: health.ReadinessPath, wantStatus: http.StatusOK, wantBody: `"Ready":true`},
+		{path: "/metrics", wantStatus: http.StatusOK, wantBody: "hookie_"},
+		{path: "/webhook", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantBody)
+		})
+	}
+}
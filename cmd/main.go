@@ -8,7 +8,15 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/thebluefowl/hookie/admin"
+	"github.com/thebluefowl/hookie/archive"
+	"github.com/thebluefowl/hookie/breaker"
+	"github.com/thebluefowl/hookie/delivery"
 	"github.com/thebluefowl/hookie/forwarder"
 	"github.com/thebluefowl/hookie/listener"
 	"github.com/thebluefowl/hookie/model"
@@ -18,34 +26,130 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+const usage = `Usage: hookie [command] [flags]
+
+Commands:
+  all       receive webhooks and deliver queued ones (default)
+  serve     receive webhooks only
+  worker    deliver queued webhooks only
+  validate  check the config and rules files and report every problem
+  test      show how a recorded HTTP request would be matched and forwarded
+  replay    re-send archived requests
+  redrive   re-publish dead-lettered deliveries until interrupted
+
+Run 'hookie <command> -h' for the flags of a command.
+`
+
+const (
+	commandAll    = "all"
+	commandServe  = "serve"
+	commandWorker = "worker"
+)
+
 func main() {
 	ctx := context.Background()
 
-	rulesPath, configPath := parseFlags()
+	command, args := commandAll, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	config := loadConfig(configPath)
-	rules := loadRules(rulesPath)
+	switch command {
+	case commandAll, commandServe, commandWorker:
+		runService(ctx, command, args)
+	case "validate":
+		runValidate(args)
+	case "test":
+		runTest(args)
+	case "replay":
+		runReplay(ctx, args)
+	case "redrive":
+		runRedrive(ctx, args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// runService runs the ingest server, the queue worker or both, so that each
+// can be scaled independently. Processes running them separately must share
+// a broker-backed queue. Delivery records are kept in memory by the server
+// alone, as neither store can be shared, so they stop at queued.
+func runService(ctx context.Context, command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var rulesPath *string
+	if command != commandWorker {
+		rulesPath = fs.String("rules", "rules.yaml", "path to rules file")
+	}
+	configPath := fs.String("config", "config.yaml", "path to config file")
+	fs.Parse(args)
 
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config := loadConfig(*configPath)
+	handleErrorWithMessage(checkSplit(command, config), "invalid config for "+command)
 	queue := initializeQueue(config)
+	breakers := initializeBreakers(config)
 
-	initializeListener(ctx, queue)
-	initializeAndRunServer(rules, config, queue)
+	var (
+		rules    []model.Rule
+		records  delivery.Store
+		archive  archive.Archive
+		consumer *listener.Listener
+		services []shutdowner
+	)
+	if command != commandWorker {
+		rules = loadRules(*rulesPath)
+		records = initializeDeliveryRecords(ctx, config)
+		archive = initializeArchive(config)
+	}
+	if command != commandServe {
+		consumer = listener.New(queue, http.DefaultTransport, breakers, records)
+	}
+	if command == commandWorker {
+		services = initializeProbeServer(config, queue, consumer)
+	} else {
+		services = initializeServer(ctx, rules, *rulesPath, config, queue, breakers, records, archive, consumer)
+	}
+
+	consumed := make(chan struct{})
+	if consumer == nil {
+		close(consumed)
+	} else {
+		go func() {
+			defer close(consumed)
+			runListener(ctx, consumer)
+		}()
+	}
+
+	<-ctx.Done()
+	// A second signal terminates the process without waiting.
+	stop()
+	shutdown(config, services, consumed, queue, records, archive)
 }
 
-func parseFlags() (string, string) {
-	var rulesPath = flag.String("rules", "rules.yaml", "path to rules file")
-	var configPath = flag.String("config", "config.yaml", "path to config file")
-	flag.Parse()
-	return *rulesPath, *configPath
+// checkSplit returns why config cannot be used by a process running only the
+// server or only the worker: the other process could not consume what it
+// queues, nor share a file of delivery records.
+func checkSplit(command string, config *Config) error {
+	if command == commandAll {
+		return nil
+	}
+	if config.RabbitMQ == nil {
+		return fmt.Errorf("the %s command needs a rabbitmq queue shared with the other process; run 'all' for a bolt or memory queue", command)
+	}
+	if config.Deliveries != nil {
+		return errors.New("delivery records cannot be shared between processes; remove deliveries or run 'all'")
+	}
+	return nil
 }
 
 func loadRules(rulesPath string) []model.Rule {
-	r, err := os.Open(rulesPath)
-	handleErrorWithMessage(err, "failed to open rules file")
-	defer closeFile(r, "failed to close rules file")
-
-	rules, err := parseRules(r)
-	handleErrorWithMessage(err, "failed to parse rules")
+	rules, err := readRules(rulesPath)
+	handleErrorWithMessage(err, "failed to load rules")
 	return rules
 }
 
@@ -72,24 +176,86 @@ func initializeQueue(config *Config) model.PubSub {
 	return queue
 }
 
-func initializeListener(ctx context.Context, queue model.PubSub) {
-	listener := listener.New(queue, http.DefaultTransport)
-	go func() {
-		if err := listener.Listen(ctx); err != nil {
-			slog.Error("listener error", slog.Any("err", err))
-			os.Exit(1)
-		}
-	}()
+// initializeBreakers returns the circuit breakers shared by the forwarders and
+// the listener, nil when they are not configured.
+func initializeBreakers(config *Config) *breaker.Set {
+	if config.CircuitBreaker == nil {
+		return nil
+	}
+	return breaker.NewSet(breaker.Settings{
+		Failures: config.CircuitBreaker.Failures,
+		OpenFor:  time.Duration(config.CircuitBreaker.OpenFor) * time.Second,
+	})
 }
 
-func initializeAndRunServer(rules []model.Rule, config *Config, queue model.PubSub) {
-	instantForwarder := forwarder.NewInstantForwarder(http.DefaultTransport)
-	queuedForwarder := forwarder.NewQueuedForwarder(queue)
+func runListener(ctx context.Context, listener *listener.Listener) {
+	if err := listener.Listen(ctx); err != nil {
+		slog.Error("listener error", slog.Any("err", err))
+		os.Exit(1)
+	}
+}
+
+func runRedrive(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("redrive", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "path to config file")
+	fs.Parse(args)
+
+	queue := initializeQueue(loadConfig(*configPath))
+	dlq, ok := queue.(model.DeadLetterConsumer)
+	if !ok {
+		handleErrorWithMessage(errors.New("queue has no dead-letter support"), "failed to redrive")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("redriving dead letters, interrupt to stop")
+	if err := listener.Redrive(ctx, dlq, queue); err != nil {
+		handleErrorWithMessage(err, "failed to redrive")
+	}
+}
+
+// initializeServer starts the webhook server and, when configured, the admin
+// server, returning them for shutdown. The server is only ready while consumer,
+// if any, is running.
+func initializeServer(ctx context.Context, rules []model.Rule, rulesPath string, config *Config, queue model.PubSub, breakers *breaker.Set, records delivery.Store, archive archive.Archive, consumer *listener.Listener) []shutdowner {
+	instantForwarder := forwarder.NewInstantForwarder(http.DefaultTransport, breakers, records)
+	queuedForwarder := forwarder.NewQueuedForwarder(queue, records)
 
 	server := server.New(rules, instantForwarder, queuedForwarder)
-	if err := server.ListenAndServe(fmt.Sprintf(":%d", config.Port)); err != nil {
-		handleErrorWithMessage(err, "failed to start server")
+	server.SetDeliveryStore(records)
+	server.SetArchive(archive)
+	addReadinessChecks(server.Probes(), queue, consumer)
+	go watchRules(ctx, rulesPath, time.Duration(config.RulesPollInterval)*time.Second, server)
+	certs := initializeTLS(ctx, config)
+	go func() {
+		addr := fmt.Sprintf(":%d", config.Port)
+		var err error
+		if certs != nil {
+			err = server.ListenAndServeTLS(addr, certs.TLSConfig())
+		} else {
+			err = server.ListenAndServe(addr)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			handleErrorWithMessage(err, "failed to start server")
+		}
+	}()
+
+	services := []shutdowner{server}
+	if config.AdminPort > 0 {
+		services = append(services, initializeAdmin(server, config, queue, records))
 	}
+	return services
+}
+
+func initializeAdmin(server *server.Server, config *Config, queue model.PubSub, records delivery.Store) *admin.Admin {
+	admin := admin.New(server, queue, records)
+	go func() {
+		if err := admin.ListenAndServe(fmt.Sprintf(":%d", config.AdminPort)); !errors.Is(err, http.ErrServerClosed) {
+			handleErrorWithMessage(err, "failed to start admin server")
+		}
+	}()
+	return admin
 }
 
 func handleErrorWithMessage(err error, message string) {
@@ -121,8 +287,22 @@ func getQueue(cfg *Config) (model.PubSub, error) {
 			ExchangeName: cfg.RabbitMQ.Exchange,
 			RoutingKey:   cfg.RabbitMQ.RoutingKey,
 			QueueName:    cfg.RabbitMQ.Queue,
+
+			DeadLetterExchangeName: cfg.RabbitMQ.DeadLetterExchange,
+			DeadLetterRoutingKey:   cfg.RabbitMQ.DeadLetterRoutingKey,
+			DeadLetterQueueName:    cfg.RabbitMQ.DeadLetterQueue,
 		}
 		return queue.NewRabbitMQ(&opts)
 	}
+	if cfg.Bolt != nil {
+		if cfg.Bolt.Path == "" {
+			return nil, errors.New("bolt queue path not configured")
+		}
+		return queue.NewBolt(cfg.Bolt.Path)
+	}
+	if cfg.Memory != nil {
+		slog.Warn("using in-memory queue, queued deliveries are lost on exit")
+		return queue.NewMemory(), nil
+	}
 	return nil, errors.New("queue not configured")
 }
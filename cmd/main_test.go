@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		config  Config
+		wantErr string
+	}{
+		{name: "all with memory queue", command: commandAll, config: Config{Memory: &Memory{}, Deliveries: &Deliveries{Path: "d.db"}}},
+		{name: "serve with rabbitmq", command: commandServe, config: Config{RabbitMQ: &RabbitMQ{}}},
+		{name: "worker with memory queue", command: commandWorker, config: Config{Memory: &Memory{}}, wantErr: "needs a rabbitmq queue"},
+		{name: "serve with bolt queue", command: commandServe, config: Config{Bolt: &Bolt{Path: "q.db"}}, wantErr: "needs a rabbitmq queue"},
+		{name: "serve with delivery records", command: commandServe, config: Config{RabbitMQ: &RabbitMQ{}, Deliveries: &Deliveries{Path: "d.db"}}, wantErr: "delivery records"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSplit(tt.command, &tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
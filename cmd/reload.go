@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/server"
+	"golang.org/x/exp/slog"
+)
+
+const defaultRulesPollInterval = 5 * time.Second
+
+// watchRules reloads the rules file into s on SIGHUP and, unless interval is
+// negative, whenever its modification time or size changes. A file that fails
+// to parse or validate is logged and the current rules keep serving.
+func watchRules(ctx context.Context, rulesPath string, interval time.Duration, s *server.Server) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if interval >= 0 {
+		if interval == 0 {
+			interval = defaultRulesPollInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	last, _ := os.Stat(rulesPath)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("RULES-RELOAD-SIGNAL", slog.String("path", rulesPath))
+		case <-poll:
+			fi, err := os.Stat(rulesPath)
+			if err != nil || (last != nil && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size()) {
+				continue
+			}
+			last = fi
+		}
+		reloadRules(rulesPath, s)
+	}
+}
+
+func reloadRules(rulesPath string, s *server.Server) {
+	rules, err := readRules(rulesPath)
+	if err != nil {
+		slog.Error("RULES-RELOAD-REJECTED", slog.String("path", rulesPath), slog.Any("err", err))
+		return
+	}
+	s.SetRules(rules)
+	slog.Info("RULES-RELOADED", slog.String("path", rulesPath), slog.Int("rules", len(rules)))
+}
+
+func readRules(rulesPath string) ([]model.Rule, error) {
+	r, err := os.Open(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	rules, err := parseRules(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.ValidateRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/thebluefowl/hookie/archive"
+	"github.com/thebluefowl/hookie/forwarder"
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/server"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	replayViaDirect = "direct"
+	replayViaQueue  = "queue"
+)
+
+type replayOptions struct {
+	rulesPath  string
+	configPath string
+	via        string
+	dryRun     bool
+	filter     archive.Filter
+}
+
+// runReplay re-sends archived requests matching the filter flags through the
+// current rules, e.g. once an upstream bug is fixed.
+func runReplay(ctx context.Context, args []string) {
+	opts, err := parseReplayFlags(args)
+	handleErrorWithMessage(err, "invalid replay flags")
+
+	config := loadConfig(opts.configPath)
+	if config.Archive == nil {
+		handleErrorWithMessage(fmt.Errorf("archive not configured"), "failed to replay")
+	}
+	a, err := openArchive(config.Archive)
+	handleErrorWithMessage(err, "failed to open archive")
+	defer a.Close()
+
+	mode := model.DeliveryModeInstant
+	var queue model.PubSub
+	if opts.via == replayViaQueue {
+		mode = model.DeliveryModeQueued
+		queue = initializeQueue(config)
+	}
+	srv := server.New(loadRules(opts.rulesPath),
+		forwarder.NewInstantForwarder(http.DefaultTransport, nil, nil),
+		forwarder.NewQueuedForwarder(queue, nil))
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var matched, replayed, failed int
+	err = a.Scan(ctx, opts.filter, func(e *archive.Entry) error {
+		matched++
+		if opts.dryRun {
+			fmt.Printf("%s\t%s\t%s\t%d\t%s %s\n", e.RequestID(), e.ReceivedAt.Format(time.RFC3339), e.Rule, e.Status, e.Request.Method, e.Request.URL)
+			return nil
+		}
+
+		rule, res, err := srv.Replay(ctx, e, mode)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("upstream responded %d", res.StatusCode)
+			}
+		}
+		if err != nil {
+			failed++
+			slog.Error("REPLAY-FAIL", slog.String("request-id", e.RequestID()), slog.String("rule", rule), slog.Any("err", err))
+			return nil
+		}
+		replayed++
+		slog.Info("REPLAY-SUCCESS", slog.String("request-id", e.RequestID()), slog.String("rule", rule), slog.Int("status-code", res.StatusCode))
+		return nil
+	})
+	slog.Info("replay finished", slog.Int("matched", matched), slog.Int("replayed", replayed), slog.Int("failed", failed))
+	handleErrorWithMessage(err, "failed to read archive")
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseReplayFlags(args []string) (*replayOptions, error) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	opts := &replayOptions{}
+	fs.StringVar(&opts.rulesPath, "rules", "rules.yaml", "path to rules file")
+	fs.StringVar(&opts.configPath, "config", "config.yaml", "path to config file")
+	fs.StringVar(&opts.via, "via", replayViaDirect, "send requests \"direct\" to the upstream or through the \"queue\"")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "list the matching requests without sending them")
+	from := fs.String("from", "", "replay requests received at or after this RFC 3339 time")
+	to := fs.String("to", "", "replay requests received before this RFC 3339 time")
+	fs.StringVar(&opts.filter.Rule, "rule", "", "replay requests that matched this rule")
+	status := fs.String("status", "", "replay requests answered with this status code or class, e.g. 502 or 5xx")
+	ids := fs.String("id", "", "replay the requests with these comma-separated IDs")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if opts.via != replayViaDirect && opts.via != replayViaQueue {
+		return nil, fmt.Errorf("unknown -via %q", opts.via)
+	}
+	var err error
+	if *from != "" {
+		if opts.filter.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return nil, fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if *to != "" {
+		if opts.filter.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return nil, fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	if *status != "" {
+		if opts.filter.Status, err = archive.ParseStatus(*status); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range strings.Split(*ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			opts.filter.RequestIDs = append(opts.filter.RequestIDs, id)
+		}
+	}
+	return opts, nil
+}
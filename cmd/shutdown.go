@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdown drains the process once it has been signalled to stop: the
+// servers stop accepting connections and finish the requests in progress,
+// while the queue consumer, whose context is already cancelled, finishes the
+// deliveries it started. Whatever is still running when the timeout expires
+// is abandoned. The queue and stores are closed last, since draining may
+// still publish to or record in them.
+func shutdown(config *Config, services []shutdowner, consumed <-chan struct{}, closers ...interface{}) {
+	timeout := time.Duration(config.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	slog.Info("SHUTDOWN-STARTED", slog.Duration("timeout", timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, s := range services {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Error("failed to drain server", slog.Any("err", err))
+		}
+	}
+	select {
+	case <-consumed:
+	case <-ctx.Done():
+		slog.Error("failed to drain queue consumer", slog.Any("err", ctx.Err()))
+	}
+
+	for _, c := range closers {
+		if c, ok := c.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Error("failed to close", slog.String("resource", fmt.Sprintf("%T", c)), slog.Any("err", err))
+			}
+		}
+	}
+	slog.Info("SHUTDOWN-COMPLETE")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/proxyutils"
+)
+
+var errNoMatch = errors.New("no rule matched")
+
+// runTest reads a raw HTTP request from a file, e.g. one captured with a
+// proxy, and prints the rule it matches and the request that would be sent
+// to the primary upstream. Nothing is sent, and signatures are not verified.
+func runTest(args []string) {
+	os.Exit(testCommand(args, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// testCommand runs test and returns its exit code: 1 when the request cannot
+// be read or matches no rule, 2 for invalid usage.
+func testCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	rulesPath := fs.String("rules", "rules.yaml", "path to rules file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hookie test [flags] <request-file>\n\nThe request file holds an HTTP/1.x request; - reads it from stdin.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	rules, err := readRules(*rulesPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load rules: %v\n", err)
+		return 1
+	}
+	req, err := readRequestFile(fs.Arg(0), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to read request: %v\n", err)
+		return 1
+	}
+	if err := testRequest(stdout, rules, req); err != nil {
+		fmt.Fprintf(stderr, "failed to test request: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readRequestFile parses an HTTP request, read from stdin when path is -.
+// Hand-written files often leave out Content-Length, in which case the rest
+// of the file is the body.
+func readRequestFile(path string, stdin io.Reader) (*http.Request, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br := bufio.NewReader(r)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request: %w", err)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if req.ContentLength <= 0 && len(req.TransferEncoding) == 0 {
+		if body, err = io.ReadAll(br); err != nil {
+			return nil, err
+		}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return req, nil
+}
+
+// testRequest writes how req would be handled by the first rule it matches.
+func testRequest(w io.Writer, rules []model.Rule, req *http.Request) error {
+	requestID := uuid.New().String()
+	for i := range rules {
+		r := &rules[i]
+		matched, err := r.TriggerSet.Match(req)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		if !matched {
+			continue
+		}
+
+		fmt.Fprintf(w, "Rule: %s\n", r.Name)
+		action := r.Action
+		if action.Weighted() {
+			var sel model.Selection
+			action, sel = action.Pick(req)
+			fmt.Fprintf(w, "Selected upstream: %s (sticky: %t)\n", sel.Upstream, sel.Sticky)
+		}
+		primary, others := action.Targets()
+		fmt.Fprintf(w, "Upstream: %s\n", primary.UpstreamHost)
+		fmt.Fprintf(w, "Delivery mode: %s\n", primary.DeliveryMode)
+		for _, o := range others {
+			fmt.Fprintf(w, "Also delivered to: %s (%s)\n", o.UpstreamHost, o.DeliveryMode)
+		}
+		if m := r.Action.Mirrored(); m != nil {
+			fmt.Fprintf(w, "Mirrored to: %s\n", m.UpstreamHost)
+		}
+
+		out, err := outboundRequest(req, requestID, primary)
+		if err != nil {
+			return err
+		}
+		dump, err := httputil.DumpRequestOut(out, true)
+		if err != nil {
+			return fmt.Errorf("failed to write outbound request: %w", err)
+		}
+		fmt.Fprintf(w, "\n%s\n", dump)
+		return nil
+	}
+	return errNoMatch
+}
+
+// outboundRequest builds the request the forwarders would send for action.
+func outboundRequest(req *http.Request, requestID string, action *model.Action) (*http.Request, error) {
+	if action.Transform != nil {
+		out, err := action.Transform.Apply(req, requestID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform request: %w", err)
+		}
+		req = out
+	}
+	tr, err := proxyutils.NewTargetRequest(requestID, req, action.URL())
+	if err != nil {
+		return nil, err
+	}
+	tr.Signing = action.Sign
+	if err := tr.Sign(); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+	return tr.Request, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestCommand(t *testing.T) {
+	const request = "POST /github HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\n\r\n{\"a\":1}"
+
+	tests := []struct {
+		name       string
+		rules      string
+		request    string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantOutput []string
+		wantError  string
+	}{
+		{
+			name:    "matched",
+			rules:   validRules,
+			request: request,
+			wantOutput: []string{
+				"Rule: github\nUpstream: http://localhost:8000\nDelivery mode: instant\n",
+				"POST /github HTTP/1.1\r\n",
+				"X-Forwarded-Host: example.com\r\n",
+				"\r\n\r\n{\"a\":1}",
+			},
+		},
+		{
+			name:       "from stdin",
+			rules:      validRules,
+			args:       []string{"-"},
+			stdin:      request,
+			wantOutput: []string{"Rule: github\n"},
+		},
+		{
+			name:      "no match",
+			rules:     validRules,
+			request:   strings.Replace(request, "/github", "/stripe", 1),
+			wantCode:  1,
+			wantError: "failed to test request: no rule matched",
+		},
+		{
+			name:      "invalid request",
+			rules:     validRules,
+			request:   "not a request",
+			wantCode:  1,
+			wantError: "failed to read request",
+		},
+		{
+			name:      "invalid rules",
+			rules:     "- name: github\n",
+			request:   request,
+			wantCode:  1,
+			wantError: "failed to load rules",
+		},
+		{name: "no request file", rules: validRules, args: []string{}, wantCode: 2, wantError: "Usage: hookie test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := []string{"-rules", writeFile(t, dir, "rules.yaml", tt.rules)}
+			if tt.args != nil {
+				args = append(args, tt.args...)
+			} else {
+				args = append(args, writeFile(t, dir, "request.http", tt.request))
+			}
+
+			var stdout, stderr bytes.Buffer
+			code := testCommand(args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code, stderr.String())
+			for _, want := range tt.wantOutput {
+				assert.Contains(t, stdout.String(), want)
+			}
+			if tt.wantError != "" {
+				assert.Contains(t, stderr.String(), tt.wantError)
+				assert.Empty(t, stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/thebluefowl/hookie/tlsconfig"
+	"golang.org/x/exp/slog"
+)
+
+const defaultCertPollInterval = time.Minute
+
+// initializeTLS loads the certificate the webhook server is served with, nil
+// when TLS is not configured, and keeps reloading it until ctx is done.
+func initializeTLS(ctx context.Context, config *Config) *tlsconfig.Reloader {
+	if config.TLS == nil {
+		return nil
+	}
+	certs, err := tlsconfig.New(config.TLS.options())
+	handleErrorWithMessage(err, "failed to initialize TLS")
+	go watchCerts(ctx, certs, time.Duration(config.TLS.PollInterval)*time.Second)
+	return certs
+}
+
+// watchCerts reloads the certificate files on SIGHUP and, unless interval is
+// negative, whenever they change. Files that fail to load are logged and the
+// current certificate keeps serving.
+func watchCerts(ctx context.Context, certs *tlsconfig.Reloader, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if interval >= 0 {
+		if interval == 0 {
+			interval = defaultCertPollInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-poll:
+		}
+		reloaded, err := certs.Reload()
+		if err != nil {
+			slog.Error("TLS-RELOAD-REJECTED", slog.Any("err", err))
+			continue
+		}
+		if reloaded {
+			slog.Info("TLS-RELOADED")
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/tlsconfig"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// problem is something wrong with a file, at a 1-based line and column when
+// known.
+type problem struct {
+	file    string
+	line    int
+	column  int
+	message string
+}
+
+func (p problem) String() string {
+	switch {
+	case p.line == 0:
+		return fmt.Sprintf("%s: %s", p.file, p.message)
+	case p.column == 0:
+		return fmt.Sprintf("%s:%d: %s", p.file, p.line, p.message)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", p.file, p.line, p.column, p.message)
+	}
+}
+
+// yamlLine matches the position yaml errors are prefixed with.
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// problemAt turns a yaml error message into a problem, taking its position
+// from the message when it has one and from node otherwise.
+func problemAt(file string, node *yamlv3.Node, message string) problem {
+	p := problem{file: file, message: message}
+	if m := yamlLine.FindStringSubmatch(message); m != nil {
+		p.line, _ = strconv.Atoi(m[1])
+		p.message = message[len(m[0]):]
+	} else if node != nil {
+		p.line, p.column = node.Line, node.Column
+	}
+	return p
+}
+
+// runValidate checks the config and rules files as serve would load them,
+// and reports every problem found instead of stopping at the first one.
+func runValidate(args []string) {
+	os.Exit(validateCommand(args, os.Stdout, os.Stderr))
+}
+
+// validateCommand runs validate and returns its exit code: 1 when there are
+// problems, 2 for invalid flags.
+func validateCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	rulesPath := fs.String("rules", "rules.yaml", "path to rules file")
+	configPath := fs.String("config", "config.yaml", "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	problems := append(validateConfigFile(*configPath), validateRulesFile(*rulesPath)...)
+	for _, p := range problems {
+		fmt.Fprintln(stderr, p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s and %s are valid\n", *configPath, *rulesPath)
+	return 0
+}
+
+// flagExitCode is the exit code of a command whose flags failed to parse
+// with err, the one flag.ExitOnError would exit with.
+func flagExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// parseYAMLFile returns the document node of a yaml file.
+func parseYAMLFile(path string) (*yamlv3.Node, []problem) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []problem{{file: path, message: err.Error()}}
+	}
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, []problem{problemAt(path, nil, err.Error())}
+	}
+	if len(doc.Content) == 0 {
+		return nil, []problem{{file: path, message: "empty file"}}
+	}
+	return doc.Content[0], nil
+}
+
+// decodeStrict decodes node into v as the server would, additionally
+// rejecting unknown fields, and reports each error at the node.
+func decodeStrict(file string, node *yamlv3.Node, v interface{}) []problem {
+	data, err := yamlv3.Marshal(node)
+	if err != nil {
+		return []problem{problemAt(file, node, err.Error())}
+	}
+	err = yaml.UnmarshalStrict(data, v)
+	if err == nil {
+		return nil
+	}
+
+	// The positions of type errors are relative to node, and so are dropped.
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return []problem{problemAt(file, node, strings.TrimPrefix(err.Error(), "yaml: "))}
+	}
+	var problems []problem
+	for _, msg := range te.Errors {
+		problems = append(problems, problem{file: file, line: node.Line, column: node.Column, message: yamlLine.ReplaceAllString(msg, "")})
+	}
+	return problems
+}
+
+// decodeNode decodes node into v and reports problems at the innermost node
+// they can be attributed to: the fields of structs and the items of lists are
+// decoded first, each on its own.
+func decodeNode(file string, node *yamlv3.Node, v interface{}) []problem {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var problems []problem
+	switch {
+	case node.Kind == yamlv3.MappingNode && t.Kind() == reflect.Struct:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			f, ok := fieldByKey(t, key.Value)
+			if !ok {
+				problems = append(problems, problemAt(file, key, fmt.Sprintf("unknown field %q", key.Value)))
+				continue
+			}
+			problems = append(problems, decodeNode(file, value, reflect.New(f.Type).Interface())...)
+		}
+	case node.Kind == yamlv3.SequenceNode && t.Kind() == reflect.Slice:
+		for _, item := range node.Content {
+			problems = append(problems, decodeNode(file, item, reflect.New(t.Elem()).Interface())...)
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return decodeStrict(file, node, v)
+}
+
+// fieldByKey returns the struct field yaml decodes key into.
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if name == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// mappingValue returns the value of key in a mapping node.
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func validateConfigFile(path string) []problem {
+	root, problems := parseYAMLFile(path)
+	if root == nil {
+		return problems
+	}
+	if root.Kind != yamlv3.MappingNode {
+		return []problem{problemAt(path, root, "config must be a mapping")}
+	}
+	config := &Config{}
+	if problems := decodeNode(path, root, config); len(problems) > 0 {
+		return problems
+	}
+
+	if config.RabbitMQ == nil && config.Bolt == nil && config.Memory == nil {
+		problems = append(problems, problemAt(path, root, "queue not configured: set one of rabbitmq, bolt or memory"))
+	}
+	if config.Bolt != nil && config.RabbitMQ == nil && config.Bolt.Path == "" {
+		problems = append(problems, problemAt(path, mappingValue(root, "bolt"), "bolt queue path not configured"))
+	}
+	if config.Archive != nil {
+		node := mappingValue(root, "archive")
+		if config.Archive.Path == "" {
+			problems = append(problems, problemAt(path, node, "archive path not configured"))
+		}
+		switch config.Archive.Backend {
+		case "", archiveBackendJSONL, archiveBackendSQLite:
+		default:
+			problems = append(problems, problemAt(path, mappingValue(node, "backend"), fmt.Sprintf("unknown archive backend %q", config.Archive.Backend)))
+		}
+	}
+	if config.TLS != nil {
+		problems = append(problems, validateTLS(path, mappingValue(root, "tls"), config.TLS)...)
+	}
+	if config.Deliveries != nil && config.Bolt != nil && config.Deliveries.Path != "" && config.Deliveries.Path == config.Bolt.Path {
+		problems = append(problems, problemAt(path, mappingValue(mappingValue(root, "deliveries"), "path"), "delivery records and the bolt queue need separate files"))
+	}
+	return problems
+}
+
+// validateTLS reports invalid settings at their node, and otherwise whether
+// the certificate files load.
+func validateTLS(path string, node *yamlv3.Node, config *TLS) []problem {
+	var problems []problem
+	if config.CertFile == "" || config.KeyFile == "" {
+		problems = append(problems, problemAt(path, node, "tls cert_file and key_file not configured"))
+	}
+	if _, err := tlsconfig.ParseVersion(config.MinVersion); err != nil {
+		problems = append(problems, problemAt(path, mappingValue(node, "min_version"), err.Error()))
+	}
+	if suites := mappingValue(node, "cipher_suites"); suites != nil {
+		for _, item := range suites.Content {
+			if _, err := tlsconfig.ParseCipherSuite(item.Value); err != nil {
+				problems = append(problems, problemAt(path, item, err.Error()))
+			}
+		}
+	}
+	if _, err := tlsconfig.ParseClientAuth(config.ClientAuth); err != nil {
+		problems = append(problems, problemAt(path, mappingValue(node, "client_auth"), err.Error()))
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	if _, err := tlsconfig.New(config.options()); err != nil {
+		problems = append(problems, problemAt(path, node, err.Error()))
+	}
+	return problems
+}
+
+// validateRulesFile reports the problems of every rule, where readRules
+// stops at the first.
+func validateRulesFile(path string) []problem {
+	root, problems := parseYAMLFile(path)
+	if root == nil {
+		return problems
+	}
+	if root.Kind != yamlv3.SequenceNode {
+		return []problem{problemAt(path, root, "rules must be a list")}
+	}
+
+	names := map[string]*yamlv3.Node{}
+	for i, node := range root.Content {
+		if node.Kind != yamlv3.MappingNode {
+			problems = append(problems, problemAt(path, node, fmt.Sprintf("rule %d: must be a mapping", i+1)))
+			continue
+		}
+
+		if name := mappingValue(node, "name"); name != nil && name.Value != "" {
+			if first, ok := names[name.Value]; ok {
+				problems = append(problems, problemAt(path, name, fmt.Sprintf("duplicate rule name %q, first used on line %d", name.Value, first.Line)))
+			} else {
+				names[name.Value] = name
+			}
+		}
+
+		var rule model.Rule
+		if ps := decodeNode(path, node, &rule); len(ps) > 0 {
+			problems = append(problems, ps...)
+			continue
+		}
+		if err := rule.Validate(); err != nil {
+			problems = append(problems, problemAt(path, node, err.Error()))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validRules = `- name: github
+  triggerset:
+    operator: and
+    triggers:
+      - name: path
+        property: path
+        comparator: equal
+        value:
+          value: /github
+  action:
+    upstream: http://localhost:8000
+    delivery_mode: instant
+    timeout: 10
+`
+
+// writeFile writes content to name in dir, returning its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		rules      string
+		args       []string
+		wantCode   int
+		wantErrors []string
+	}{
+		{name: "valid", config: "port: 8080\nmemory: {}\n", rules: validRules},
+		{
+			name:       "no queue",
+			config:     "port: 8080\n",
+			rules:      validRules,
+			wantCode:   1,
+			wantErrors: []string{"config.yaml:1:1: queue not configured"},
+		},
+		{
+			name:       "unknown config field",
+			config:     "port: 8080\nmemory: {}\nqueue: rabbit\n",
+			rules:      validRules,
+			wantCode:   1,
+			wantErrors: []string{`config.yaml:3:1: unknown field "queue"`},
+		},
+		{
+			name:     "every problem",
+			config:   "port: 8080\nbolt: {}\narchive:\n  backend: redis\n",
+			rules:    validRules + validRules,
+			wantCode: 1,
+			wantErrors: []string{
+				"config.yaml:2:7: bolt queue path not configured",
+				"config.yaml:4:3: archive path not configured",
+				`config.yaml:4:12: unknown archive backend "redis"`,
+				`rules.yaml:14:9: duplicate rule name "github", first used on line 1`,
+			},
+		},
+		{
+			name:       "invalid rule",
+			config:     "port: 8080\nmemory: {}\n",
+			rules:      "- name: github\n  action:\n    upstream: http://localhost:8000\n",
+			wantCode:   1,
+			wantErrors: []string{`rules.yaml:1:3: invalid rule "github": missing triggerset`},
+		},
+		{
+			name:       "rules not a list",
+			config:     "port: 8080\nmemory: {}\n",
+			rules:      "name: github\n",
+			wantCode:   1,
+			wantErrors: []string{"rules.yaml:1:1: rules must be a list"},
+		},
+		{name: "unknown flag", args: []string{"-verbose"}, wantCode: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := tt.args
+			if args == nil {
+				args = []string{
+					"-config", writeFile(t, dir, "config.yaml", tt.config),
+					"-rules", writeFile(t, dir, "rules.yaml", tt.rules),
+				}
+			}
+
+			var stdout, stderr bytes.Buffer
+			code := validateCommand(args, &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code, stderr.String())
+			for _, want := range tt.wantErrors {
+				assert.Contains(t, stderr.String(), want)
+			}
+			if tt.wantCode == 0 {
+				assert.Contains(t, stdout.String(), "are valid")
+				assert.Empty(t, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultCapacity is the number of keys the in-memory store remembers.
+const DefaultCapacity = 10000
+
+var now = time.Now
+
+// Pending is the status of a key reserved by a request still being
+// forwarded.
+const Pending = 0
+
+// Store remembers the response status of requests by idempotency key, so
+// that redeliveries can be answered without forwarding them again.
+// Implementations backed by an external store let several instances share
+// what they have seen.
+type Store interface {
+	// Reserve atomically marks key as pending for the given time to live
+	// unless it is already known, and reports whether it did. When it did
+	// not, the status recorded for key is returned, Pending while the request
+	// that reserved it has not finished.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (int, bool, error)
+	// Set records status for key for the given time to live.
+	Set(ctx context.Context, key string, status int, ttl time.Duration) error
+	// Release forgets key, so that the next request with it is forwarded.
+	Release(ctx context.Context, key string) error
+}
+
+// Memory is a Store holding up to a fixed number of keys in process,
+// evicting the least recently used first.
+type Memory struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	status  int
+	expires time.Time
+}
+
+func NewMemory(capacity int) *Memory {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Memory{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the status recorded for key, false when there is none or it
+// has expired.
+func (m *Memory) Get(ctx context.Context, key string) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(key)
+}
+
+func (m *Memory) Reserve(ctx context.Context, key string, ttl time.Duration) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if status, ok, _ := m.get(key); ok {
+		return status, false, nil
+	}
+	m.set(key, Pending, ttl)
+	return Pending, true, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, status int, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, status, ttl)
+	return nil
+}
+
+func (m *Memory) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	return nil
+}
+
+// get must be called with mu held.
+func (m *Memory) get(key string) (int, bool, error) {
+	el, ok := m.entries[key]
+	if !ok {
+		return 0, false, nil
+	}
+	e := el.Value.(*entry)
+	if !now().Before(e.expires) {
+		m.remove(el)
+		return 0, false, nil
+	}
+	m.order.MoveToFront(el)
+	return e.status, true, nil
+}
+
+// set must be called with mu held.
+func (m *Memory) set(key string, status int, ttl time.Duration) {
+	expires := now().Add(ttl)
+	if el, ok := m.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.status, e.expires = status, expires
+		m.order.MoveToFront(el)
+		return
+	}
+
+	m.entries[key] = m.order.PushFront(&entry{key: key, status: status, expires: expires})
+	for m.order.Len() > m.capacity {
+		m.remove(m.order.Back())
+	}
+}
+
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// remove must be called with mu held.
+func (m *Memory) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.entries, el.Value.(*entry).key)
+}
@@ -0,0 +1,86 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	t0 := time.Unix(1700000000, 0)
+	now = func() time.Time { return t0 }
+	ctx := context.Background()
+
+	m := NewMemory(2)
+	_, ok, err := m.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, m.Set(ctx, "a", 200, time.Minute))
+	require.NoError(t, m.Set(ctx, "b", 202, time.Hour))
+	status, ok, _ := m.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, 200, status)
+
+	// "b" is now the least recently used and is evicted first.
+	require.NoError(t, m.Set(ctx, "c", 201, time.Hour))
+	assert.Equal(t, 2, m.Len())
+	_, ok, _ = m.Get(ctx, "b")
+	assert.False(t, ok)
+
+	now = func() time.Time { return t0.Add(time.Minute) }
+	_, ok, _ = m.Get(ctx, "a")
+	assert.False(t, ok, "expired")
+	assert.Equal(t, 1, m.Len())
+	status, ok, _ = m.Get(ctx, "c")
+	assert.True(t, ok)
+	assert.Equal(t, 201, status)
+}
+
+func TestMemory_Reserve(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(10)
+
+	status, ok, err := m.Reserve(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Pending, status)
+
+	status, ok, _ = m.Reserve(ctx, "a", time.Minute)
+	assert.False(t, ok, "pending")
+	assert.Equal(t, Pending, status)
+
+	require.NoError(t, m.Set(ctx, "a", 200, time.Minute))
+	status, ok, _ = m.Reserve(ctx, "a", time.Minute)
+	assert.False(t, ok)
+	assert.Equal(t, 200, status)
+
+	require.NoError(t, m.Release(ctx, "a"))
+	_, ok, _ = m.Reserve(ctx, "a", time.Minute)
+	assert.True(t, ok, "released")
+}
+
+func TestMemory_ReserveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(10)
+
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := m.Reserve(ctx, "a", time.Minute); ok {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), reserved.Load())
+}
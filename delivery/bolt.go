@@ -0,0 +1,98 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltDeliveriesBucket = []byte("deliveries")
+
+// Bolt persists records in a bbolt file, so that they survive restarts. The
+// file cannot be shared with the bolt queue.
+type Bolt struct {
+	db *bolt.DB
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open delivery records: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltDeliveriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create delivery records bucket: %w", err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func (b *Bolt) Get(ctx context.Context, id string) (*Record, error) {
+	r := &Record{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltDeliveriesBucket).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *Bolt) Update(ctx context.Context, id string, fn func(r *Record)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDeliveriesBucket)
+		r := &Record{}
+		if v := bucket.Get([]byte(id)); v != nil {
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+		}
+		fn(r)
+		r.RequestID = id
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), v)
+	})
+}
+
+// Prune deletes the records last updated before the given time.
+func (b *Bolt) Prune(ctx context.Context, before time.Time) (int, error) {
+	var stale [][]byte
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDeliveriesBucket)
+		err := bucket.ForEach(func(k, v []byte) error {
+			r := &Record{}
+			if err := json.Unmarshal(v, r); err != nil || r.UpdatedAt.Before(before) {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Keys are deleted after iterating since deleting moves the cursor.
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(stale), err
+}
@@ -0,0 +1,134 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+type State string
+
+const (
+	StateReceived     State = "received"
+	StateQueued       State = "queued"
+	StateDelivered    State = "delivered"
+	StateFailed       State = "failed"
+	StateDeadLettered State = "dead-lettered"
+)
+
+var ErrNotFound = errors.New("delivery record not found")
+
+var now = time.Now
+
+// Record is the delivery history of one request, as returned to the caller
+// under its request ID. It follows the primary upstream only; fan-out and
+// mirror deliveries are not recorded.
+type Record struct {
+	RequestID      string
+	Rule           string
+	State          State
+	Attempts       int
+	LastStatus     int    `json:",omitempty"`
+	LastError      string `json:",omitempty"`
+	ReceivedAt     time.Time
+	QueuedAt       *time.Time `json:",omitempty"`
+	NextAttemptAt  *time.Time `json:",omitempty"`
+	DeliveredAt    *time.Time `json:",omitempty"`
+	DeadLetteredAt *time.Time `json:",omitempty"`
+	UpdatedAt      time.Time
+}
+
+// Store persists delivery records.
+type Store interface {
+	// Get returns the record of id, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Record, error)
+	// Update applies fn to the record of id, creating it if needed.
+	Update(ctx context.Context, id string, fn func(r *Record)) error
+}
+
+// Update applies fn to the record of id in store. It does nothing when there
+// is no store, and failures are only logged since they must not affect
+// delivery.
+func Update(ctx context.Context, store Store, id string, fn func(r *Record)) {
+	if store == nil || id == "" {
+		return
+	}
+	err := store.Update(ctx, id, func(r *Record) {
+		r.RequestID = id
+		fn(r)
+		r.UpdatedAt = now()
+	})
+	if err != nil {
+		slog.Error("failed to update delivery record", slog.String("request-id", id), slog.Any("err", err))
+	}
+}
+
+func Received(rule string) func(r *Record) {
+	return func(r *Record) {
+		r.Rule = rule
+		r.State = StateReceived
+		r.ReceivedAt = now()
+	}
+}
+
+// Queued records that the request waits in the queue until notBefore, zero
+// meaning as soon as possible.
+func Queued(notBefore time.Time) func(r *Record) {
+	return func(r *Record) {
+		t := now()
+		r.State = StateQueued
+		if r.QueuedAt == nil {
+			r.QueuedAt = &t
+		}
+		r.NextAttemptAt = nil
+		if !notBefore.IsZero() {
+			r.NextAttemptAt = &notBefore
+		}
+	}
+}
+
+// Attempted records one delivery attempt. A status of 0 means no response
+// was received. Successful attempts complete the delivery; failed ones leave
+// the state to be settled by what happens next.
+func Attempted(status int, err error) func(r *Record) {
+	return func(r *Record) {
+		r.Attempts++
+		r.LastStatus = status
+		r.LastError = ""
+		r.NextAttemptAt = nil
+		if err != nil {
+			r.LastError = err.Error()
+		}
+		if err == nil && status < http.StatusInternalServerError {
+			t := now()
+			r.State = StateDelivered
+			r.DeliveredAt = &t
+		} else {
+			r.State = StateFailed
+		}
+	}
+}
+
+func DeadLettered() func(r *Record) {
+	return func(r *Record) {
+		t := now()
+		r.State = StateDeadLettered
+		r.DeadLetteredAt = &t
+	}
+}
+
+type trackedKey struct{}
+
+// WithTracking marks ctx as delivering the request whose record is kept.
+func WithTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trackedKey{}, true)
+}
+
+// Tracked reports whether deliveries made with ctx update the record.
+func Tracked(ctx context.Context) bool {
+	tracked, _ := ctx.Value(trackedKey{}).(bool)
+	return tracked
+}
@@ -0,0 +1,97 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdate_Lifecycle(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	t0 := time.Unix(1700000000, 0).UTC()
+	now = func() time.Time { return t0 }
+
+	bolt, err := NewBolt(filepath.Join(t.TempDir(), "deliveries.db"))
+	require.NoError(t, err)
+	defer bolt.Close()
+
+	for name, store := range map[string]Store{"memory": NewMemory(10), "bolt": bolt} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			_, err := store.Get(ctx, "req-1")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			Update(ctx, store, "req-1", Received("stripe"))
+			Update(ctx, store, "req-1", Attempted(0, errors.New("connection refused")))
+			retryAt := t0.Add(time.Minute)
+			Update(ctx, store, "req-1", Queued(retryAt))
+
+			r, err := store.Get(ctx, "req-1")
+			require.NoError(t, err)
+			assert.Equal(t, "req-1", r.RequestID)
+			assert.Equal(t, "stripe", r.Rule)
+			assert.Equal(t, StateQueued, r.State)
+			assert.Equal(t, 1, r.Attempts)
+			assert.Equal(t, "connection refused", r.LastError)
+			require.NotNil(t, r.NextAttemptAt)
+			assert.True(t, retryAt.Equal(*r.NextAttemptAt))
+
+			Update(ctx, store, "req-1", Attempted(200, nil))
+			r, err = store.Get(ctx, "req-1")
+			require.NoError(t, err)
+			assert.Equal(t, StateDelivered, r.State)
+			assert.Equal(t, 2, r.Attempts)
+			assert.Equal(t, 200, r.LastStatus)
+			assert.Empty(t, r.LastError)
+			assert.Nil(t, r.NextAttemptAt)
+			require.NotNil(t, r.DeliveredAt)
+		})
+	}
+}
+
+func TestUpdate_NilStore(t *testing.T) {
+	Update(context.Background(), nil, "req-1", Received("stripe"))
+}
+
+func TestMemory_Evicts(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(2)
+	Update(ctx, m, "a", Received("r"))
+	Update(ctx, m, "b", Received("r"))
+	Update(ctx, m, "a", DeadLettered())
+	Update(ctx, m, "c", Received("r"))
+
+	_, err := m.Get(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	r, err := m.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, StateDeadLettered, r.State)
+}
+
+func TestBolt_Prune(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	t0 := time.Unix(1700000000, 0)
+	ctx := context.Background()
+
+	b, err := NewBolt(filepath.Join(t.TempDir(), "deliveries.db"))
+	require.NoError(t, err)
+	defer b.Close()
+
+	now = func() time.Time { return t0 }
+	Update(ctx, b, "old", Received("r"))
+	now = func() time.Time { return t0.Add(time.Hour) }
+	Update(ctx, b, "new", Received("r"))
+
+	n, err := b.Prune(ctx, t0.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = b.Get(ctx, "old")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = b.Get(ctx, "new")
+	assert.NoError(t, err)
+}
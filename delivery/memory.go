@@ -0,0 +1,63 @@
+package delivery
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// DefaultCapacity is the number of records the in-memory store keeps.
+const DefaultCapacity = 10000
+
+// Memory keeps the most recently updated records in process.
+type Memory struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	records map[string]*list.Element
+}
+
+func NewMemory(capacity int) *Memory {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Memory{
+		capacity: capacity,
+		order:    list.New(),
+		records:  make(map[string]*list.Element),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	r := *el.Value.(*Record)
+	return &r, nil
+}
+
+func (m *Memory) Update(ctx context.Context, id string, fn func(r *Record)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.records[id]
+	if !ok {
+		el = m.order.PushFront(&Record{})
+		m.records[id] = el
+		for m.order.Len() > m.capacity {
+			oldest := m.order.Back()
+			m.order.Remove(oldest)
+			delete(m.records, oldest.Value.(*Record).RequestID)
+		}
+	}
+	r := el.Value.(*Record)
+	fn(r)
+	r.RequestID = id
+	m.order.MoveToFront(el)
+	return nil
+}
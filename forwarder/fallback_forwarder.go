@@ -3,8 +3,8 @@ package forwarder
 import (
 	"context"
 	"net/http"
-	"net/url"
 
+	"github.com/thebluefowl/hookie/metrics"
 	"github.com/thebluefowl/hookie/model"
 	"golang.org/x/exp/slog"
 )
@@ -21,12 +21,17 @@ func NewFallbackForwarder(instantForwarder *InstantForwarder, queuedForwarder *Q
 	}
 }
 
-func (fw *FallbackForwarder) Forward(ctx context.Context, req *http.Request, target *url.URL) (*http.Response, error) {
+func (fw *FallbackForwarder) Forward(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error) {
 	requestID := ctx.Value(model.ContextKey("request-id")).(string)
-	res, err := fw.instantForwarder.Forward(ctx, req, target)
-	if err != nil || res.StatusCode < http.StatusInternalServerError {
+	res, err := fw.instantForwarder.Forward(ctx, req, action)
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		if res != nil {
+			res.Body.Close()
+		}
+		rule, _ := ctx.Value(model.ContextKey("rule")).(string)
+		metrics.FallbackToQueued.WithLabelValues(rule).Inc()
 		slog.Info("FALLBACK-TO-QUEUED", slog.String("request-id", requestID))
-		return fw.queuedForwarder.Forward(ctx, req, target)
+		return fw.queuedForwarder.Forward(ctx, req, action)
 	} else {
 		return res, nil
 	}
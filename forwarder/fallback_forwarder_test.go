@@ -0,0 +1,53 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/breaker"
+	"github.com/thebluefowl/hookie/model"
+)
+
+type fakePublisher struct {
+	published [][]byte
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, payload []byte) error {
+	f.published = append(f.published, payload)
+	return nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFallbackForwarder_CircuitBreaker(t *testing.T) {
+	calls := 0
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+	})
+	publisher := &fakePublisher{}
+	breakers := breaker.NewSet(breaker.Settings{Failures: 2})
+	fw := NewFallbackForwarder(NewInstantForwarder(transport, breakers, nil), NewQueuedForwarder(publisher, nil))
+
+	action := &model.Action{UpstreamHost: "http://upstream", DeliveryMode: model.DeliveryModeFallback}
+	ctx := context.WithValue(context.Background(), model.ContextKey("request-id"), "req-1")
+	for i := 0; i < 4; i++ {
+		res, err := fw.Forward(ctx, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("event")), action)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	}
+
+	// Once the breaker opened, requests are queued without trying the upstream.
+	assert.Equal(t, 2, calls)
+	assert.Len(t, publisher.published, 4)
+	assert.Equal(t, breaker.Open, breakers.Get("upstream").State())
+}
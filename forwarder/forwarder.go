@@ -3,9 +3,10 @@ package forwarder
 import (
 	"context"
 	"net/http"
-	"net/url"
+
+	"github.com/thebluefowl/hookie/model"
 )
 
 type Forwarder interface {
-	Forward(ctx context.Context, req *http.Request, target *url.URL) (*http.Response, error)
+	Forward(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error)
 }
@@ -2,10 +2,13 @@ package forwarder
 
 import (
 	"context"
+	"io"
 	"net/http"
-	"net/url"
 	"time"
 
+	"github.com/thebluefowl/hookie/breaker"
+	"github.com/thebluefowl/hookie/delivery"
+	"github.com/thebluefowl/hookie/metrics"
 	"github.com/thebluefowl/hookie/model"
 	"github.com/thebluefowl/hookie/proxyutils"
 	"golang.org/x/exp/slog"
@@ -17,34 +20,91 @@ var now = func() int64 {
 
 type InstantForwarder struct {
 	roundTripper http.RoundTripper
+	breakers     *breaker.Set
+	records      delivery.Store
 }
 
-func NewInstantForwarder(roundTripper http.RoundTripper) *InstantForwarder {
+// NewInstantForwarder creates a forwarder sending requests with roundTripper.
+// While the breaker of an upstream host is open, requests to it fail with
+// breaker.ErrOpen without being sent; a nil breakers disables this. Attempts
+// of tracked requests are recorded in records, if set.
+func NewInstantForwarder(roundTripper http.RoundTripper, breakers *breaker.Set, records delivery.Store) *InstantForwarder {
 	if roundTripper == nil {
 		roundTripper = http.DefaultTransport
 	}
 
 	return &InstantForwarder{
 		roundTripper: roundTripper,
+		breakers:     breakers,
+		records:      records,
 	}
 }
 
-func (fw *InstantForwarder) Forward(ctx context.Context, req *http.Request, target *url.URL) (*http.Response, error) {
+func (fw *InstantForwarder) Forward(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error) {
 	requestID := ctx.Value(model.ContextKey("request-id")).(string)
-	targetRequest, err := proxyutils.NewTargetRequest(requestID, req, target)
+	rule, _ := ctx.Value(model.ContextKey("rule")).(string)
+	targetRequest, err := proxyutils.NewTargetRequest(requestID, req, action.URL())
 	if err != nil {
 		return nil, err
 	}
+	targetRequest.Signing = action.Sign
+	if err := targetRequest.Sign(); err != nil {
+		return nil, err
+	}
+
+	cancel := func() {}
+	if timeout := action.Timeout(); timeout > 0 {
+		var tctx context.Context
+		tctx, cancel = context.WithTimeout(targetRequest.Request.Context(), timeout)
+		targetRequest.Request = targetRequest.Request.WithContext(tctx)
+	}
+
+	b := fw.breakers.Get(targetRequest.Request.URL.Host)
+	if !b.Allow() {
+		cancel()
+		slog.Warn("REQUEST-SKIPPED", slog.String("request-id", requestID), slog.String("upstream", targetRequest.Request.URL.Host), slog.Any("err", breaker.ErrOpen))
+		return nil, breaker.ErrOpen
+	}
 
 	slog.Info("REQUEST-SENDING", slog.String("request-id", requestID))
 	t0 := now()
 	res, err := fw.roundTripper.RoundTrip(targetRequest.Request)
 	t1 := now()
-	slog.Info("RESPONSE-RECEIVED", slog.String("request-id", requestID), slog.Int("status-code", res.StatusCode), slog.Int64("duration-ms", t1-t0))
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		b.Failure()
+	} else {
+		b.Success()
+	}
+	if delivery.Tracked(ctx) {
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+		}
+		delivery.Update(ctx, fw.records, requestID, delivery.Attempted(status, err))
+	}
 	if err != nil {
+		cancel()
+		metrics.ObserveUpstream(rule, action.DeliveryMode, 0, time.Duration(t1-t0)*time.Millisecond)
+		slog.Error("REQUEST-FAILED", slog.String("request-id", requestID), slog.Any("err", err), slog.Int64("duration-ms", t1-t0))
 		return nil, err
 	}
+	metrics.ObserveUpstream(rule, action.DeliveryMode, res.StatusCode, time.Duration(t1-t0)*time.Millisecond)
+	slog.Info("RESPONSE-RECEIVED", slog.String("request-id", requestID), slog.Int("status-code", res.StatusCode), slog.Int64("duration-ms", t1-t0))
+
+	// The deadline also covers reading the body, so release it only once the
+	// caller is done with the response.
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
 
 	targetResponse := proxyutils.NewTargetResponse(res)
 	return targetResponse.Response, nil
 }
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
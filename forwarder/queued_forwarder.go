@@ -1,11 +1,16 @@
 package forwarder
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
+	"time"
 
+	"github.com/thebluefowl/hookie/delivery"
+	"github.com/thebluefowl/hookie/metrics"
 	"github.com/thebluefowl/hookie/model"
 	"github.com/thebluefowl/hookie/proxyutils"
 	"golang.org/x/exp/slog"
@@ -17,20 +22,37 @@ type Publisher interface {
 
 type QueuedForwarder struct {
 	publisher Publisher
+	records   delivery.Store
 }
 
-func NewQueuedForwarder(publisher Publisher) *QueuedForwarder {
+// NewQueuedForwarder creates a forwarder publishing requests to publisher.
+// Tracked requests are recorded as queued in records, if set.
+func NewQueuedForwarder(publisher Publisher, records delivery.Store) *QueuedForwarder {
 	return &QueuedForwarder{
 		publisher: publisher,
+		records:   records,
 	}
 }
 
-func (fw *QueuedForwarder) Forward(ctx context.Context, req *http.Request, target *url.URL) (*http.Response, error) {
+// Accepted is the body returned for queued requests, so that callers can
+// look up the delivery later.
+type Accepted struct {
+	RequestID string
+	State     delivery.State
+}
+
+func (fw *QueuedForwarder) Forward(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error) {
 	requestID := ctx.Value(model.ContextKey("request-id")).(string)
-	out, err := proxyutils.NewTargetRequest(requestID, req, target)
+	out, err := proxyutils.NewTargetRequest(requestID, req, action.URL())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create target request: %w", err)
 	}
+	out.Rule, _ = ctx.Value(model.ContextKey("rule")).(string)
+	out.Retry = action.RetryPolicy()
+	out.Timeout = action.Timeout()
+	out.Signing = action.Sign
+	out.RateLimit = action.RateLimit
+	out.Tracked = delivery.Tracked(ctx)
 
 	payload, err := out.MarshalJSON()
 	if err != nil {
@@ -38,13 +60,25 @@ func (fw *QueuedForwarder) Forward(ctx context.Context, req *http.Request, targe
 	}
 
 	slog.Info("PUBLISH-ATTEMPT", slog.String("request-id", requestID))
-	if err := fw.publisher.Publish(ctx, payload); err != nil {
+	err = fw.publisher.Publish(ctx, payload)
+	metrics.QueuePublishes.WithLabelValues(metrics.Result(err)).Inc()
+	if err != nil {
 		slog.Error("PUBLISH-FAIL", slog.String("request-id", requestID), slog.Any("err", err))
 		return nil, fmt.Errorf("failed to publish target request: %w", err)
 	}
 	slog.Info("PUBLISH-SUCCESS", slog.String("request-id", requestID))
+	if out.Tracked {
+		delivery.Update(ctx, fw.records, requestID, delivery.Queued(time.Time{}))
+	}
 
+	body, err := json.Marshal(Accepted{RequestID: requestID, State: delivery.StateQueued})
+	if err != nil {
+		return nil, err
+	}
 	return &http.Response{
-		StatusCode: http.StatusAccepted,
+		StatusCode:    http.StatusAccepted,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
 	}, nil
 }
@@ -0,0 +1,103 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// checkTimeout bounds how long a readiness probe waits for its checks.
+const checkTimeout = 5 * time.Second
+
+// Check returns why the process cannot take traffic, or nil when it can.
+type Check func(ctx context.Context) error
+
+// Probes answers liveness and readiness probes. The process is live as long
+// as it answers, and ready when every check passes.
+type Probes struct {
+	mu     sync.RWMutex
+	names  []string
+	checks map[string]Check
+}
+
+func New() *Probes {
+	return &Probes{checks: make(map[string]Check)}
+}
+
+// Add registers a readiness check, replacing any of the same name.
+func (p *Probes) Add(name string, check Check) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.checks[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.checks[name] = check
+}
+
+// Handles reports whether path is one of the probe paths.
+func (p *Probes) Handles(path string) bool {
+	return path == LivenessPath || path == ReadinessPath
+}
+
+// Readiness is the outcome of a readiness probe, with the result of each
+// check: "ok" or the reason it failed.
+type Readiness struct {
+	Ready  bool
+	Checks map[string]string
+}
+
+// Ready runs every check.
+func (p *Probes) Ready(ctx context.Context) Readiness {
+	p.mu.RLock()
+	names := append([]string(nil), p.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = p.checks[name]
+	}
+	p.mu.RUnlock()
+
+	r := Readiness{Ready: true, Checks: make(map[string]string, len(names))}
+	for i, name := range names {
+		if err := checks[i](ctx); err != nil {
+			r.Ready = false
+			r.Checks[name] = err.Error()
+			continue
+		}
+		r.Checks[name] = "ok"
+	}
+	return r
+}
+
+func (p *Probes) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case LivenessPath:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	case ReadinessPath:
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+
+		r := p.Ready(ctx)
+		status := http.StatusOK
+		if !r.Ready {
+			status = http.StatusServiceUnavailable
+			slog.Warn("NOT-READY", slog.Any("checks", r.Checks))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(r); err != nil {
+			slog.Error("failed to write readiness", slog.Any("err", err))
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
@@ -0,0 +1,41 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbes(t *testing.T) {
+	p := New()
+	assert.True(t, p.Handles(LivenessPath))
+	assert.True(t, p.Handles(ReadinessPath))
+	assert.False(t, p.Handles("/hook"))
+
+	var queueErr error
+	p.Add("queue", func(ctx context.Context) error { return queueErr })
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"Ready": true, "Checks": {"queue": "ok"}}`, rec.Body.String())
+
+	queueErr = errors.New("disconnected")
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"Ready": false, "Checks": {"queue": "disconnected"}}`, rec.Body.String())
+
+	// Liveness does not depend on the checks.
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, LivenessPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A check added again replaces the earlier one.
+	p.Add("queue", func(ctx context.Context) error { return nil })
+	assert.Equal(t, Readiness{Ready: true, Checks: map[string]string{"queue": "ok"}}, p.Ready(context.Background()))
+}
@@ -6,58 +6,289 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/thebluefowl/hookie/breaker"
+	"github.com/thebluefowl/hookie/delivery"
+	"github.com/thebluefowl/hookie/metrics"
 	"github.com/thebluefowl/hookie/model"
 	"github.com/thebluefowl/hookie/proxyutils"
 	"github.com/thebluefowl/hookie/queue"
+	"github.com/thebluefowl/hookie/ratelimit"
 	"golang.org/x/exp/slog"
 )
 
+// errNotDue is returned for deliveries consumed before they are due from a
+// queue that cannot delay them, so that the queue requeues them.
+var errNotDue = errors.New("delivery not due yet")
+
 var now = func() int64 {
 	return time.Now().UnixMilli()
 }
 
 type Listener struct {
-	consumer  model.Consumer
-	transport http.RoundTripper
+	pubsub      model.PubSub
+	delayed     model.DelayedPublisher
+	deadLetters model.DeadLetterPublisher
+	transport   http.RoundTripper
+	breakers    *breaker.Set
+	limiter     *ratelimit.Limiter
+	records     delivery.Store
+	running     atomic.Bool
 }
 
-func New(consumer model.Consumer, transport http.RoundTripper) *Listener {
+// New creates a Listener consuming from pubsub. Failed deliveries are
+// published back to it until their retry policy is exhausted, after which
+// they are dead-lettered if pubsub supports it and discarded otherwise.
+//
+// Deliveries that cannot be sent yet are published back with a delay if
+// pubsub supports it, and requeued otherwise: those not due, those to an
+// upstream whose breaker is open, and those over the rate limit of their rule
+// for the upstream host. A nil breakers disables the breakers. The outcome of
+// tracked deliveries is recorded in records, if set.
+func New(pubsub model.PubSub, transport http.RoundTripper, breakers *breaker.Set, records delivery.Store) *Listener {
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
+	delayed, _ := pubsub.(model.DelayedPublisher)
+	deadLetters, _ := pubsub.(model.DeadLetterPublisher)
 	return &Listener{
-		consumer:  consumer,
-		transport: transport,
+		pubsub:      pubsub,
+		delayed:     delayed,
+		deadLetters: deadLetters,
+		transport:   transport,
+		breakers:    breakers,
+		limiter:     ratelimit.New(),
+		records:     records,
 	}
 }
 
+// Listen consumes queued deliveries until ctx is done. Deliveries being sent
+// are then completed and acknowledged before it returns. The consumer never
+// waits for a delivery to become due, so that one does not hold up the rest.
 func (l *Listener) Listen(ctx context.Context) error {
-	return l.consumer.StartConsumer(ctx, func(body interface{}) error {
-		b, ok := body.([]byte)
-		if !ok {
-			return queue.NewError(errors.New("payload should be []byte"), true)
-		}
-		tr := &proxyutils.TargetRequest{}
-		if err := json.Unmarshal(b, tr); err != nil {
-			return queue.NewError(fmt.Errorf("failed to unmarshal payload: %w", err), true)
-		}
+	l.running.Store(true)
+	defer l.running.Store(false)
+	return l.pubsub.StartConsumer(ctx, func(body interface{}) error {
+		err := l.handle(ctx, body)
+		metrics.ConsumerResults.WithLabelValues(consumerOutcome(err)).Inc()
+		return err
+	})
+}
 
-		slog.Info("LISTENER-REQUEST-SENDING", slog.String("request-id", tr.ID))
-		t0 := now()
-		resp, err := l.transport.RoundTrip(tr.Request)
-		t1 := now()
-		slog.Info("LISTENER-RESPONSE-RECEIVED", slog.String("request-id", tr.ID), slog.Int("status-code", resp.StatusCode), slog.Int64("duration-ms", t1-t0))
-		if err != nil {
-			return queue.NewError(fmt.Errorf("failed to forward request: %w", err), false)
-		}
-		defer resp.Body.Close()
+// Running reports whether Listen is consuming.
+func (l *Listener) Running() bool {
+	return l.running.Load()
+}
 
-		if resp.StatusCode > http.StatusInternalServerError {
-			return queue.NewError(fmt.Errorf("failed to forward request: %s", resp.Status), false)
+func (l *Listener) handle(ctx context.Context, body interface{}) error {
+	b, ok := body.([]byte)
+	if !ok {
+		return queue.NewError(errors.New("payload should be []byte"), true)
+	}
+	tr := &proxyutils.TargetRequest{}
+	if err := json.Unmarshal(b, tr); err != nil {
+		err = fmt.Errorf("failed to unmarshal payload: %w", err)
+		return l.deadLetter(ctx, &model.DeadLetter{Payload: b, Error: err.Error(), FailedAt: time.Now()}, err)
+	}
 
-		}
+	if d := time.Until(tr.NotBefore); d > 0 {
+		return l.postpone(ctx, tr.ID, b, d, "retry")
+	}
+
+	if d := l.throttle(tr); d > 0 {
+		return l.postpone(ctx, tr.ID, b, d, "throttled")
+	}
+
+	// Once started, the attempt and its outcome are seen through even if the
+	// listener is stopping, so that the message is acknowledged accordingly.
+	// A request that cannot be signed is never sent, and so says nothing
+	// about the upstream's health.
+	if err := tr.Sign(); err != nil {
+		ctx = uncancelled{ctx}
+		tr.Attempts++
+		err = fmt.Errorf("failed to sign request: %w", err)
+		l.record(ctx, tr, delivery.Attempted(0, err))
+		return l.retry(ctx, tr, 0, err)
+	}
+
+	cb := l.breakers.Get(tr.Request.URL.Host)
+	if !cb.Allow() {
+		return l.postpone(ctx, tr.ID, b, cb.Wait(), "circuit-open")
+	}
+
+	ctx = uncancelled{ctx}
+	tr.Attempts++
+	status, err := l.deliver(ctx, tr)
+	l.record(ctx, tr, delivery.Attempted(status, err))
+	if err == nil {
+		cb.Success()
 		return nil
-	})
+	}
+	cb.Failure()
+	return l.retry(ctx, tr, status, err)
 }
+
+// throttle returns how long until the rate limit of tr, if any, allows it.
+// Each rule limits its deliveries to an upstream host on its own.
+func (l *Listener) throttle(tr *proxyutils.TargetRequest) time.Duration {
+	rl := tr.RateLimit
+	if rl == nil {
+		return 0
+	}
+	host := tr.Request.URL.Host
+	ok, d := l.limiter.Allow(rl.Bucket(tr.Rule+"\x00"+host, tr.Request), rl.Rate, rl.Burst)
+	if ok {
+		return 0
+	}
+	metrics.ThrottledDeliveries.WithLabelValues(host).Inc()
+	return d
+}
+
+func (l *Listener) deliver(ctx context.Context, tr *proxyutils.TargetRequest) (int, error) {
+	if tr.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tr.Timeout)
+		defer cancel()
+	}
+
+	slog.Info("LISTENER-REQUEST-SENDING", slog.String("request-id", tr.ID), slog.Int("attempt", tr.Attempts))
+	t0 := now()
+	resp, err := l.transport.RoundTrip(tr.Request.WithContext(ctx))
+	t1 := now()
+	metrics.ObserveUpstream(tr.Rule, model.DeliveryModeQueued, statusCode(resp), time.Duration(t1-t0)*time.Millisecond)
+	if err != nil {
+		slog.Error("LISTENER-REQUEST-FAILED", slog.String("request-id", tr.ID), slog.Any("err", err), slog.Int64("duration-ms", t1-t0))
+		return 0, fmt.Errorf("failed to forward request: %w", err)
+	}
+	defer resp.Body.Close()
+	slog.Info("LISTENER-RESPONSE-RECEIVED", slog.String("request-id", tr.ID), slog.Int("status-code", resp.StatusCode), slog.Int64("duration-ms", t1-t0))
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resp.StatusCode, fmt.Errorf("failed to forward request: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retry schedules the next attempt by publishing the request back to the
+// queue with the attempt count and the earliest time it may be delivered.
+func (l *Listener) retry(ctx context.Context, tr *proxyutils.TargetRequest, status int, cause error) error {
+	body, err := tr.Request.GetBody()
+	if err != nil {
+		return queue.NewError(fmt.Errorf("failed to rewind request body: %w", err), false)
+	}
+	tr.Request.Body = body
+
+	exhausted := tr.Attempts > tr.Retry.Retries
+	if !exhausted {
+		tr.NotBefore = time.Now().Add(tr.Retry.Next(tr.Attempts))
+	}
+
+	payload, err := tr.MarshalJSON()
+	if err != nil {
+		return queue.NewError(fmt.Errorf("failed to marshal retry: %w", err), false)
+	}
+
+	if exhausted {
+		slog.Error("LISTENER-RETRIES-EXHAUSTED", slog.String("request-id", tr.ID), slog.Int("attempts", tr.Attempts))
+		err := l.deadLetter(ctx, &model.DeadLetter{
+			RequestID:  tr.ID,
+			Rule:       tr.Rule,
+			Attempts:   tr.Attempts,
+			LastStatus: status,
+			Error:      cause.Error(),
+			FailedAt:   time.Now(),
+			Payload:    payload,
+		}, cause)
+		if err == nil {
+			l.record(ctx, tr, delivery.DeadLettered())
+		}
+		return err
+	}
+	err = l.publishAfter(ctx, payload, time.Until(tr.NotBefore))
+	metrics.QueuePublishes.WithLabelValues(metrics.Result(err)).Inc()
+	if err != nil {
+		return queue.NewError(fmt.Errorf("failed to publish retry: %w", err), false)
+	}
+	slog.Info("LISTENER-RETRY-SCHEDULED", slog.String("request-id", tr.ID), slog.Int("attempt", tr.Attempts), slog.Time("not-before", tr.NotBefore))
+	l.record(ctx, tr, delivery.Queued(tr.NotBefore))
+	return nil
+}
+
+// postpone publishes payload back to the queue to be consumed once d has
+// passed, acknowledging the current copy.
+func (l *Listener) postpone(ctx context.Context, requestID string, payload []byte, d time.Duration, reason string) error {
+	if l.delayed == nil {
+		return queue.NewError(errNotDue, false)
+	}
+	err := l.delayed.PublishDelayed(ctx, payload, d)
+	metrics.QueuePublishes.WithLabelValues(metrics.Result(err)).Inc()
+	if err != nil {
+		return queue.NewError(fmt.Errorf("failed to postpone delivery: %w", err), false)
+	}
+	slog.Info("LISTENER-POSTPONED", slog.String("request-id", requestID), slog.String("reason", reason), slog.Duration("delay", d))
+	return nil
+}
+
+// publishAfter publishes payload to be consumed once d has passed, or right
+// away when the queue cannot delay it.
+func (l *Listener) publishAfter(ctx context.Context, payload []byte, d time.Duration) error {
+	if l.delayed == nil || d <= 0 {
+		return l.pubsub.Publish(ctx, payload)
+	}
+	return l.delayed.PublishDelayed(ctx, payload, d)
+}
+
+func (l *Listener) record(ctx context.Context, tr *proxyutils.TargetRequest, fn func(r *delivery.Record)) {
+	if tr.Tracked {
+		delivery.Update(ctx, l.records, tr.ID, fn)
+	}
+}
+
+// deadLetter hands a delivery that cannot succeed to the dead-letter queue.
+// Without one, the delivery is discarded.
+func (l *Listener) deadLetter(ctx context.Context, dl *model.DeadLetter, cause error) error {
+	if l.deadLetters == nil {
+		return queue.NewError(cause, true)
+	}
+	if err := l.deadLetters.PublishDeadLetter(ctx, dl); err != nil {
+		slog.Error("LISTENER-DEAD-LETTER-FAIL", slog.String("request-id", dl.RequestID), slog.Any("err", err))
+		return queue.NewError(fmt.Errorf("failed to publish dead letter: %w", err), false)
+	}
+	slog.Info("LISTENER-DEAD-LETTERED", slog.String("request-id", dl.RequestID), slog.String("rule", dl.Rule), slog.Int("attempts", dl.Attempts))
+	return nil
+}
+
+// consumerOutcome tells what the queue does with a message given the error
+// returned for it.
+func consumerOutcome(err error) string {
+	var qerr *queue.Error
+	switch {
+	case err == nil:
+		return metrics.ConsumerAck
+	case errors.As(err, &qerr) && qerr.IsFatal():
+		return metrics.ConsumerDiscard
+	default:
+		return metrics.ConsumerRequeue
+	}
+}
+
+func statusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// uncancelled carries the values of its parent context but not its deadline
+// or cancellation.
+type uncancelled struct {
+	context.Context
+}
+
+func (uncancelled) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (uncancelled) Done() <-chan struct{} { return nil }
+
+func (uncancelled) Err() error { return nil }
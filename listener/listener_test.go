@@ -0,0 +1,373 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/breaker"
+	"github.com/thebluefowl/hookie/delivery"
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/proxyutils"
+	"github.com/thebluefowl/hookie/queue"
+)
+
+type fakePubSub struct {
+	deliveries [][]byte
+	published  [][]byte
+	// delays of the published payloads, zero for those published right away.
+	delays  []time.Duration
+	results []error
+}
+
+func (f *fakePubSub) Publish(ctx context.Context, payload []byte) error {
+	return f.PublishDelayed(ctx, payload, 0)
+}
+
+func (f *fakePubSub) PublishDelayed(ctx context.Context, payload []byte, delay time.Duration) error {
+	f.published = append(f.published, payload)
+	f.delays = append(f.delays, delay)
+	return nil
+}
+
+// undelayedPubSub hides the PublishDelayed method of the queue it wraps.
+type undelayedPubSub struct {
+	model.PubSub
+}
+
+func (f *fakePubSub) StartConsumer(ctx context.Context, processor func(body interface{}) error) error {
+	for _, d := range f.deliveries {
+		f.results = append(f.results, processor(d))
+	}
+	return nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newPayload(t *testing.T, attempts, retries int) []byte {
+	req, err := http.NewRequest(http.MethodPost, "http://upstream/hook", strings.NewReader("payload"))
+	require.NoError(t, err)
+	tr := &proxyutils.TargetRequest{
+		ID:       "req-1",
+		Request:  req,
+		Attempts: attempts,
+		Retry:    model.RetryPolicy{Retries: retries, Delay: time.Minute},
+	}
+	b, err := tr.MarshalJSON()
+	require.NoError(t, err)
+	return b
+}
+
+func TestListener_Listen(t *testing.T) {
+	tests := []struct {
+		name          string
+		attempts      int
+		retries       int
+		status        int
+		wantFatal     bool
+		wantPublished bool
+	}{
+		{name: "success acks", retries: 1, status: http.StatusOK},
+		{name: "failure is retried", retries: 1, status: http.StatusBadGateway, wantPublished: true},
+		{name: "failure after last retry is fatal", attempts: 1, retries: 1, status: http.StatusInternalServerError, wantFatal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := &fakePubSub{deliveries: [][]byte{newPayload(t, tt.attempts, tt.retries)}}
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				body, err := io.ReadAll(req.Body)
+				require.NoError(t, err)
+				assert.Equal(t, "payload", string(body))
+				return &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(""))}, nil
+			})
+
+			err := New(ps, transport, nil, nil).Listen(context.Background())
+			require.NoError(t, err)
+			require.Len(t, ps.results, 1)
+
+			if tt.wantFatal {
+				var qerr *queue.Error
+				require.True(t, errors.As(ps.results[0], &qerr))
+				assert.True(t, qerr.IsFatal())
+			} else {
+				assert.NoError(t, ps.results[0])
+			}
+
+			if tt.wantPublished {
+				require.Len(t, ps.published, 1)
+				tr := &proxyutils.TargetRequest{}
+				require.NoError(t, tr.UnmarshalJSON(ps.published[0]))
+				assert.Equal(t, tt.attempts+1, tr.Attempts)
+				assert.False(t, tr.NotBefore.IsZero())
+				assert.InDelta(t, time.Minute, ps.delays[0], float64(time.Second), "published with the retry delay")
+				body, _ := io.ReadAll(tr.Request.Body)
+				assert.Equal(t, "payload", string(body))
+			} else {
+				assert.Empty(t, ps.published)
+			}
+		})
+	}
+}
+
+type fakeDeadLetterPubSub struct {
+	fakePubSub
+	deadLetters []*model.DeadLetter
+}
+
+func (f *fakeDeadLetterPubSub) PublishDeadLetter(ctx context.Context, dl *model.DeadLetter) error {
+	f.deadLetters = append(f.deadLetters, dl)
+	return nil
+}
+
+func (f *fakeDeadLetterPubSub) StartDeadLetterConsumer(ctx context.Context, processor func(dl *model.DeadLetter) error) error {
+	for _, dl := range f.deadLetters {
+		f.results = append(f.results, processor(dl))
+	}
+	return nil
+}
+
+func TestListener_ListenDeadLetter(t *testing.T) {
+	ps := &fakeDeadLetterPubSub{fakePubSub: fakePubSub{deliveries: [][]byte{newPayload(t, 1, 1), []byte("garbage")}}}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable", Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	err := New(ps, transport, nil, nil).Listen(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, ps.results)
+	assert.Empty(t, ps.published)
+	require.Len(t, ps.deadLetters, 2)
+
+	dl := ps.deadLetters[0]
+	assert.Equal(t, "req-1", dl.RequestID)
+	assert.Equal(t, 2, dl.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, dl.LastStatus)
+	assert.Contains(t, dl.Error, "503")
+	tr := &proxyutils.TargetRequest{}
+	require.NoError(t, tr.UnmarshalJSON(dl.Payload))
+	body, _ := io.ReadAll(tr.Request.Body)
+	assert.Equal(t, "payload", string(body))
+
+	assert.Equal(t, []byte("garbage"), ps.deadLetters[1].Payload)
+	assert.Contains(t, ps.deadLetters[1].Error, "failed to unmarshal payload")
+}
+
+func TestListener_ListenSigned(t *testing.T) {
+	t.Setenv("HOOKIE_TEST_SIGNING_SECRET", "s3cr3t")
+	signing := &model.Signing{SecretEnv: "HOOKIE_TEST_SIGNING_SECRET"}
+
+	req, err := http.NewRequest(http.MethodPost, "http://upstream/hook", strings.NewReader("payload"))
+	require.NoError(t, err)
+	tr := &proxyutils.TargetRequest{ID: "req-1", Request: req, Signing: signing}
+	payload, err := tr.MarshalJSON()
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), "s3cr3t", "the secret stays out of the queue")
+
+	ps := &fakePubSub{deliveries: [][]byte{payload}}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "req-1", req.Header.Get(model.HeaderWebhookID))
+		assert.NotEmpty(t, req.Header.Get(model.HeaderWebhookTimestamp))
+		assert.True(t, strings.HasPrefix(req.Header.Get(model.HeaderWebhookSignature), "v1,"))
+		body, _ := io.ReadAll(req.Body)
+		assert.Equal(t, "payload", string(body))
+
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	require.NoError(t, New(ps, transport, nil, nil).Listen(context.Background()))
+	assert.Equal(t, []error{nil}, ps.results)
+
+	// Without the secret in its environment, the consumer dead-letters it
+	// without holding it against the upstream.
+	t.Setenv("HOOKIE_TEST_SIGNING_SECRET", "")
+	breakers := breaker.NewSet(breaker.Settings{Failures: 1, OpenFor: time.Minute})
+	dps := &fakeDeadLetterPubSub{fakePubSub: fakePubSub{deliveries: [][]byte{payload}}}
+	require.NoError(t, New(dps, transport, breakers, nil).Listen(context.Background()))
+	require.Len(t, dps.deadLetters, 1)
+	assert.Contains(t, dps.deadLetters[0].Error, "failed to resolve signing secret")
+	assert.Equal(t, breaker.Closed, breakers.Get("upstream").State())
+
+	// Inline secrets cannot be queued.
+	tr = &proxyutils.TargetRequest{ID: "req-1", Request: req, Signing: model.NewSigning([]byte("secret"))}
+	_, err = tr.MarshalJSON()
+	assert.ErrorIs(t, err, model.ErrInlineSecret)
+}
+
+func TestListener_Running(t *testing.T) {
+	var l *Listener
+	var running bool
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		running = l.Running()
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+	l = New(&fakePubSub{deliveries: [][]byte{newPayload(t, 0, 1)}}, transport, nil, nil)
+
+	assert.False(t, l.Running())
+	require.NoError(t, l.Listen(context.Background()))
+	assert.True(t, running)
+	assert.False(t, l.Running())
+}
+
+func TestListener_ListenRecordsTracked(t *testing.T) {
+	newTracked := func(attempts int) []byte {
+		req, err := http.NewRequest(http.MethodPost, "http://upstream/hook", strings.NewReader("payload"))
+		require.NoError(t, err)
+		tr := &proxyutils.TargetRequest{ID: "req-1", Request: req, Attempts: attempts, Retry: model.RetryPolicy{Retries: 1}, Tracked: true}
+		b, err := tr.MarshalJSON()
+		require.NoError(t, err)
+		return b
+	}
+	ctx := context.Background()
+	records := delivery.NewMemory(0)
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	ps := &fakeDeadLetterPubSub{fakePubSub: fakePubSub{deliveries: [][]byte{newTracked(0)}}}
+	require.NoError(t, New(ps, transport, nil, records).Listen(ctx))
+	r, err := records.Get(ctx, "req-1")
+	require.NoError(t, err)
+	assert.Equal(t, delivery.StateQueued, r.State)
+	assert.Equal(t, 1, r.Attempts)
+	assert.Equal(t, http.StatusBadGateway, r.LastStatus)
+	require.NotNil(t, r.NextAttemptAt)
+
+	ps = &fakeDeadLetterPubSub{fakePubSub: fakePubSub{deliveries: [][]byte{newTracked(1)}}}
+	require.NoError(t, New(ps, transport, nil, records).Listen(ctx))
+	r, err = records.Get(ctx, "req-1")
+	require.NoError(t, err)
+	assert.Equal(t, delivery.StateDeadLettered, r.State)
+	assert.Equal(t, 2, r.Attempts)
+	assert.NotNil(t, r.DeadLetteredAt)
+
+	// Untracked deliveries, e.g. fan-out copies, leave no record.
+	ps = &fakeDeadLetterPubSub{fakePubSub: fakePubSub{deliveries: [][]byte{newPayload(t, 0, 1)}}}
+	records = delivery.NewMemory(0)
+	require.NoError(t, New(ps, transport, nil, records).Listen(ctx))
+	_, err = records.Get(ctx, "req-1")
+	assert.ErrorIs(t, err, delivery.ErrNotFound)
+}
+
+func TestListener_ListenCompletesDeliveryWhenStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ps := &fakePubSub{deliveries: [][]byte{newPayload(t, 0, 1)}}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	require.NoError(t, New(ps, transport, nil, nil).Listen(ctx))
+	assert.Equal(t, []error{nil}, ps.results)
+	assert.Empty(t, ps.published)
+}
+
+func TestListener_ListenPostponesDeliveryNotDue(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://upstream/hook", strings.NewReader("payload"))
+	require.NoError(t, err)
+	tr := &proxyutils.TargetRequest{ID: "req-1", Request: req, NotBefore: time.Now().Add(time.Hour)}
+	payload, err := tr.MarshalJSON()
+	require.NoError(t, err)
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Fatal("delivery should not be attempted")
+		return nil, nil
+	})
+
+	ps := &fakePubSub{deliveries: [][]byte{payload}}
+	require.NoError(t, New(ps, transport, nil, nil).Listen(context.Background()))
+	assert.Equal(t, []error{nil}, ps.results, "acknowledged without waiting")
+	assert.Equal(t, [][]byte{payload}, ps.published)
+	assert.InDelta(t, time.Hour, ps.delays[0], float64(time.Minute))
+
+	// Queues that cannot delay messages requeue it instead.
+	ps = &fakePubSub{deliveries: [][]byte{payload}}
+	require.NoError(t, New(undelayedPubSub{ps}, transport, nil, nil).Listen(context.Background()))
+	require.Len(t, ps.results, 1)
+	var qerr *queue.Error
+	require.ErrorAs(t, ps.results[0], &qerr)
+	assert.False(t, qerr.IsFatal(), "requeued")
+	assert.ErrorIs(t, qerr.Err, errNotDue)
+	assert.Empty(t, ps.published)
+}
+
+func TestListener_ListenPostponesForOpenBreaker(t *testing.T) {
+	breakers := breaker.NewSet(breaker.Settings{Failures: 1, OpenFor: time.Minute})
+	breakers.Get("upstream").Failure()
+
+	req, err := http.NewRequest(http.MethodPost, "http://other/hook", strings.NewReader("payload"))
+	require.NoError(t, err)
+	other, err := (&proxyutils.TargetRequest{ID: "req-2", Request: req}).MarshalJSON()
+	require.NoError(t, err)
+	payload := newPayload(t, 0, 1)
+
+	var delivered []string
+	ps := &fakePubSub{deliveries: [][]byte{payload, other}}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		delivered = append(delivered, req.URL.Host)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	require.NoError(t, New(ps, transport, breakers, nil).Listen(context.Background()))
+	assert.Equal(t, []error{nil, nil}, ps.results)
+	assert.Equal(t, []string{"other"}, delivered, "other upstreams are not held up")
+	assert.Equal(t, [][]byte{payload}, ps.published)
+	assert.InDelta(t, time.Minute, ps.delays[0], float64(time.Second))
+	assert.Equal(t, breaker.Open, breakers.Get("upstream").State())
+}
+
+func TestListener_ListenThrottled(t *testing.T) {
+	newLimited := func(rule string) []byte {
+		req, err := http.NewRequest(http.MethodPost, "http://upstream/hook", strings.NewReader("payload"))
+		require.NoError(t, err)
+		tr := &proxyutils.TargetRequest{ID: "req-1", Request: req, Rule: rule, RateLimit: &model.RateLimit{Rate: 1, Burst: 1}}
+		payload, err := tr.MarshalJSON()
+		require.NoError(t, err)
+		return payload
+	}
+	orders, refunds := newLimited("orders"), newLimited("refunds")
+
+	sent := 0
+	ps := &fakePubSub{deliveries: [][]byte{orders, orders, refunds}}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	require.NoError(t, New(ps, transport, nil, nil).Listen(context.Background()))
+	assert.Equal(t, []error{nil, nil, nil}, ps.results)
+	assert.Equal(t, 2, sent, "other rules have their own limit for the host")
+	assert.Equal(t, [][]byte{orders}, ps.published)
+	assert.InDelta(t, time.Second, ps.delays[0], float64(100*time.Millisecond))
+}
+
+func TestRedrive(t *testing.T) {
+	ps := &fakeDeadLetterPubSub{deadLetters: []*model.DeadLetter{
+		{RequestID: "req-1", Attempts: 2, Payload: newPayload(t, 2, 1)},
+		{Payload: []byte("garbage")},
+	}}
+
+	err := Redrive(context.Background(), ps, ps)
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, ps.results)
+	require.Len(t, ps.published, 2)
+
+	tr := &proxyutils.TargetRequest{}
+	require.NoError(t, tr.UnmarshalJSON(ps.published[0]))
+	assert.Equal(t, 0, tr.Attempts)
+	assert.Equal(t, 1, tr.Retry.Retries)
+	assert.Equal(t, []byte("garbage"), ps.published[1])
+}
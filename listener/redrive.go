@@ -0,0 +1,37 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/proxyutils"
+	"golang.org/x/exp/slog"
+)
+
+// Redrive re-publishes dead letters to the delivery queue with a fresh retry
+// budget until ctx is done.
+func Redrive(ctx context.Context, dlq model.DeadLetterConsumer, publisher model.Publisher) error {
+	return dlq.StartDeadLetterConsumer(ctx, func(dl *model.DeadLetter) error {
+		payload := dl.Payload
+
+		// Payloads that could not be decoded in the first place are
+		// re-published untouched.
+		tr := &proxyutils.TargetRequest{}
+		if err := json.Unmarshal(dl.Payload, tr); err == nil {
+			tr.Attempts = 0
+			tr.NotBefore = time.Time{}
+			if payload, err = tr.MarshalJSON(); err != nil {
+				return err
+			}
+		}
+
+		if err := publisher.Publish(ctx, payload); err != nil {
+			slog.Error("REDRIVE-FAIL", slog.String("request-id", dl.RequestID), slog.Any("err", err))
+			return err
+		}
+		slog.Info("REDRIVE-SUCCESS", slog.String("request-id", dl.RequestID), slog.String("rule", dl.Rule), slog.Int("attempts", dl.Attempts))
+		return nil
+	})
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "hookie"
+
+// MirrorDropped is the result of a shadow copy not sent because too many
+// were in flight.
+const MirrorDropped = "dropped"
+
+// Consumer outcomes for a queued delivery.
+const (
+	ConsumerAck     = "ack"
+	ConsumerRequeue = "requeue"
+	ConsumerDiscard = "discard"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	IncomingRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "incoming_requests_total",
+		Help:      "Requests received on the webhook port, by rule, empty for those that matched none.",
+	}, []string{"rule"})
+
+	RuleMatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_matches_total",
+		Help:      "Requests matched, by rule.",
+	}, []string{"rule"})
+
+	RuleNoMatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_no_matches_total",
+		Help:      "Requests that matched no rule.",
+	})
+
+	VerificationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "verification_failures_total",
+		Help:      "Requests rejected because their signature did not verify, by rule.",
+	}, []string{"rule"})
+
+	DuplicateRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_requests_total",
+		Help:      "Redelivered requests answered without forwarding, by rule.",
+	}, []string{"rule"})
+
+	RateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests over their rule's rate limit, by rule and outcome (rejected or queued).",
+	}, []string{"rule", "outcome"})
+
+	UpstreamSelections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_selections_total",
+		Help:      "Requests assigned to an upstream of a weighted action, by rule and upstream.",
+	}, []string{"rule", "upstream"})
+
+	UpstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Calls made to upstreams, by rule, delivery mode and status class.",
+	}, []string{"rule", "delivery_mode", "status_class"})
+
+	UpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_duration_seconds",
+		Help:      "Latency of calls made to upstreams, by rule and delivery mode.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"rule", "delivery_mode"})
+
+	MirrorRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mirror_requests_total",
+		Help:      "Shadow copies sent to mirror upstreams, by rule and result (success, failure or dropped).",
+	}, []string{"rule", "result"})
+
+	QueuePublishes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_publishes_total",
+		Help:      "Requests published to the queue, by result.",
+	}, []string{"result"})
+
+	ConsumerResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_results_total",
+		Help:      "Queued deliveries handled by the listener, by outcome.",
+	}, []string{"outcome"})
+
+	FallbackToQueued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fallback_to_queued_total",
+		Help:      "Fallback deliveries that were queued after the instant attempt failed, by rule.",
+	}, []string{"rule"})
+
+	ThrottledDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "throttled_deliveries_total",
+		Help:      "Queued deliveries delayed by an upstream rate limit, by upstream host.",
+	}, []string{"upstream"})
+
+	CircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "Circuit breaker state by upstream host: 0 closed, 1 open, 2 half-open.",
+	}, []string{"upstream"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		IncomingRequests,
+		RuleMatches,
+		RuleNoMatches,
+		VerificationFailures,
+		DuplicateRequests,
+		RateLimitedRequests,
+		UpstreamSelections,
+		UpstreamRequests,
+		UpstreamDuration,
+		MirrorRequests,
+		QueuePublishes,
+		ConsumerResults,
+		FallbackToQueued,
+		ThrottledDeliveries,
+		CircuitBreakerState,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveUpstream records one upstream call. A status of 0 means the call
+// failed before a response was received.
+func ObserveUpstream(rule, deliveryMode string, status int, duration time.Duration) {
+	UpstreamRequests.WithLabelValues(rule, deliveryMode, StatusClass(status)).Inc()
+	UpstreamDuration.WithLabelValues(rule, deliveryMode).Observe(duration.Seconds())
+}
+
+// StatusClass buckets a status code as "2xx", "5xx" and so on, or "error"
+// when there is no status.
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "error"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// Result labels an operation by whether it failed.
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
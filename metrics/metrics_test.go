@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", StatusClass(200))
+	assert.Equal(t, "4xx", StatusClass(429))
+	assert.Equal(t, "5xx", StatusClass(503))
+	assert.Equal(t, "error", StatusClass(0))
+}
+
+func TestResult(t *testing.T) {
+	assert.Equal(t, "success", Result(nil))
+	assert.Equal(t, "failure", Result(errors.New("boom")))
+}
+
+func TestObserveUpstream(t *testing.T) {
+	ObserveUpstream("rule_1", "instant", 502, 10*time.Millisecond)
+	ObserveUpstream("rule_1", "instant", 0, time.Second)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(UpstreamRequests.WithLabelValues("rule_1", "instant", "5xx")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(UpstreamRequests.WithLabelValues("rule_1", "instant", "error")))
+	assert.Equal(t, 1, testutil.CollectAndCount(UpstreamDuration))
+}
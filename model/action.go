@@ -1,6 +1,11 @@
 package model
 
-import "net/url"
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"time"
+)
 
 const (
 	DeliveryModeInstant  = "instant"
@@ -8,15 +13,216 @@ const (
 	DeliveryModeQueued   = "queued"
 )
 
+const (
+	BackoffConstant    = "constant"
+	BackoffExponential = "exponential"
+)
+
+// maxRetryDelay caps the wait between two queued delivery attempts.
+const maxRetryDelay = time.Hour
+
+var jitter = rand.Int63n
+
+// Action describes where and how a matched request is delivered. TimeOut and
+// Delay are expressed in seconds.
 type Action struct {
-	UpstreamHost string `yaml:"upstream"`
-	DeliveryMode string `yaml:"delivery_mode"`
-	TimeOut      int    `yaml:"timeout"`
-	Delay        int    `yaml:"delay"`
-	Retries      int    `yaml:"retries"`
+	UpstreamHost string      `yaml:"upstream"`
+	Upstreams    []Upstream  `yaml:"upstreams"`
+	DeliveryMode string      `yaml:"delivery_mode"`
+	TimeOut      int         `yaml:"timeout"`
+	Delay        int         `yaml:"delay"`
+	Retries      int         `yaml:"retries"`
+	Backoff      string      `yaml:"backoff"`
+	Jitter       bool        `yaml:"jitter"`
+	Sign         *Signing    `yaml:"sign"`
+	Transform    *Transform  `yaml:"transform"`
+	Sticky       *RequestKey `yaml:"sticky"`
+	Mirror       string      `yaml:"mirror"`
+	RateLimit    *RateLimit  `yaml:"rate_limit"`
+}
+
+// Upstream is one of several targets an action fans out to or, when weights
+// are set, splits traffic between. An empty DeliveryMode, Sign or RateLimit
+// inherits the action's.
+type Upstream struct {
+	Name         string     `yaml:"name"`
+	URL          string     `yaml:"url"`
+	DeliveryMode string     `yaml:"delivery_mode"`
+	Primary      bool       `yaml:"primary"`
+	Weight       int        `yaml:"weight"`
+	Sign         *Signing   `yaml:"sign"`
+	RateLimit    *RateLimit `yaml:"rate_limit"`
+}
+
+func (a *Action) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Action
+	if err := unmarshal((*plain)(a)); err != nil {
+		return err
+	}
+	return a.Validate()
+}
+
+func (a *Action) Validate() error {
+	if a.TimeOut < 0 || a.Delay < 0 || a.Retries < 0 {
+		return fmt.Errorf("%w: timeout, delay and retries must not be negative", ErrInvalidAction)
+	}
+	switch a.Backoff {
+	case "", BackoffConstant, BackoffExponential:
+	default:
+		return fmt.Errorf("%w: unknown backoff %q", ErrInvalidAction, a.Backoff)
+	}
+	if a.UpstreamHost != "" && len(a.Upstreams) > 0 {
+		return fmt.Errorf("%w: upstream and upstreams are mutually exclusive", ErrInvalidAction)
+	}
+	if a.RateLimit != nil && a.RateLimit.Exceeded != "" {
+		return fmt.Errorf("%w: exceeded only applies to rule rate limits", ErrInvalidAction)
+	}
+	primaries := 0
+	for _, u := range a.Upstreams {
+		if u.RateLimit != nil && u.RateLimit.Exceeded != "" {
+			return fmt.Errorf("%w: exceeded only applies to rule rate limits", ErrInvalidAction)
+		}
+		if u.URL == "" {
+			return fmt.Errorf("%w: upstream %q has no url", ErrInvalidAction, u.Name)
+		}
+		if u.Weight < 0 {
+			return fmt.Errorf("%w: upstream %q has a negative weight", ErrInvalidAction, u.Name)
+		}
+		if u.Primary {
+			primaries++
+		}
+	}
+	if primaries > 1 {
+		return fmt.Errorf("%w: only one upstream can be primary", ErrInvalidAction)
+	}
+	if a.Weighted() && primaries > 0 {
+		return fmt.Errorf("%w: weighted upstreams cannot be primary", ErrInvalidAction)
+	}
+	if a.Sticky != nil {
+		if !a.Weighted() {
+			return fmt.Errorf("%w: sticky requires weighted upstreams", ErrInvalidAction)
+		}
+		if err := a.Sticky.Validate(); err != nil {
+			return fmt.Errorf("%w: sticky: %w", ErrInvalidAction, err)
+		}
+	}
+	return nil
+}
+
+// Targets splits the action into one action per upstream. The primary is the
+// upstream marked as such, or the first one; its response is the one returned
+// to the caller. Weighted actions list every upstream here but deliver to a
+// single one, see Pick.
+func (a *Action) Targets() (primary *Action, others []*Action) {
+	if len(a.Upstreams) == 0 {
+		return a, nil
+	}
+	p := 0
+	for i, u := range a.Upstreams {
+		if u.Primary {
+			p = i
+			break
+		}
+	}
+	for i, u := range a.Upstreams {
+		if i == p {
+			primary = a.target(u)
+		} else {
+			others = append(others, a.target(u))
+		}
+	}
+	return primary, others
+}
+
+// target returns a copy of the action delivering to u alone.
+func (a *Action) target(u Upstream) *Action {
+	t := *a
+	t.UpstreamHost = u.URL
+	t.Upstreams = nil
+	t.Sticky = nil
+	t.Mirror = ""
+	if u.DeliveryMode != "" {
+		t.DeliveryMode = u.DeliveryMode
+	}
+	if u.Sign != nil {
+		t.Sign = u.Sign
+	}
+	if u.RateLimit != nil {
+		t.RateLimit = u.RateLimit
+	}
+	return &t
+}
+
+// Queued returns a copy of the action delivering every target through the
+// queue, without mirroring.
+func (a *Action) Queued() *Action {
+	t := *a
+	t.DeliveryMode = DeliveryModeQueued
+	t.Mirror = ""
+	t.Upstreams = make([]Upstream, len(a.Upstreams))
+	for i, u := range a.Upstreams {
+		u.DeliveryMode = DeliveryModeQueued
+		t.Upstreams[i] = u
+	}
+	return &t
+}
+
+// Mirrored returns the action delivering a shadow copy to the mirror URL, nil
+// when there is none. Mirrors are always delivered instantly, never retried
+// and not rate limited.
+func (a *Action) Mirrored() *Action {
+	if a.Mirror == "" {
+		return nil
+	}
+	t := a.target(Upstream{URL: a.Mirror, DeliveryMode: DeliveryModeInstant})
+	t.Retries = 0
+	t.RateLimit = nil
+	return t
 }
 
 func (a *Action) URL() *url.URL {
 	u, _ := url.Parse(a.UpstreamHost)
 	return u
 }
+
+// Timeout returns the deadline for a single upstream call, zero meaning none.
+func (a *Action) Timeout() time.Duration {
+	return time.Duration(a.TimeOut) * time.Second
+}
+
+func (a *Action) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Retries: a.Retries,
+		Delay:   time.Duration(a.Delay) * time.Second,
+		Backoff: a.Backoff,
+		Jitter:  a.Jitter,
+	}
+}
+
+// RetryPolicy travels with a queued request so that consumers can retry it
+// without access to the rules.
+type RetryPolicy struct {
+	Retries int
+	Delay   time.Duration
+	Backoff string
+	Jitter  bool
+}
+
+// Next returns how long to wait before retrying after the given failed
+// attempt, starting at 1.
+func (p RetryPolicy) Next(attempt int) time.Duration {
+	d := p.Delay
+	if p.Backoff == BackoffExponential {
+		for i := 1; i < attempt && d < maxRetryDelay; i++ {
+			d *= 2
+		}
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	if p.Jitter && d > 1 {
+		// Wait somewhere between half and all of the computed delay.
+		d = d/2 + time.Duration(jitter(int64(d/2)+1))
+	}
+	return d
+}
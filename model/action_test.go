@@ -0,0 +1,104 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestAction_UnmarshalYAML(t *testing.T) {
+	a := &Action{}
+	err := yaml.Unmarshal([]byte(`upstream: http://localhost:8000
+delivery_mode: queued
+timeout: 10
+delay: 5
+retries: 3
+backoff: exponential
+jitter: true`), a)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, a.Timeout())
+	assert.Equal(t, RetryPolicy{Retries: 3, Delay: 5 * time.Second, Backoff: BackoffExponential, Jitter: true}, a.RetryPolicy())
+
+	err = yaml.Unmarshal([]byte(`backoff: linear`), &Action{})
+	assert.ErrorIs(t, err, ErrInvalidAction)
+
+	err = yaml.Unmarshal([]byte(`retries: -1`), &Action{})
+	assert.ErrorIs(t, err, ErrInvalidAction)
+}
+
+func TestRetryPolicy_Next(t *testing.T) {
+	constant := RetryPolicy{Delay: 10 * time.Second}
+	assert.Equal(t, 10*time.Second, constant.Next(1))
+	assert.Equal(t, 10*time.Second, constant.Next(4))
+
+	exponential := RetryPolicy{Delay: 10 * time.Second, Backoff: BackoffExponential}
+	assert.Equal(t, 10*time.Second, exponential.Next(1))
+	assert.Equal(t, 20*time.Second, exponential.Next(2))
+	assert.Equal(t, 80*time.Second, exponential.Next(4))
+	assert.Equal(t, maxRetryDelay, exponential.Next(100))
+
+	defer func(orig func(int64) int64) { jitter = orig }(jitter)
+	jitter = func(n int64) int64 { return n - 1 }
+	jittered := RetryPolicy{Delay: 10 * time.Second, Jitter: true}
+	assert.Equal(t, 10*time.Second, jittered.Next(1))
+	jitter = func(n int64) int64 { return 0 }
+	assert.Equal(t, 5*time.Second, jittered.Next(1))
+}
+
+func TestAction_Targets(t *testing.T) {
+	single := &Action{UpstreamHost: "http://a", DeliveryMode: DeliveryModeInstant}
+	primary, others := single.Targets()
+	assert.Same(t, single, primary)
+	assert.Empty(t, others)
+
+	shared, own := NewSigning([]byte("shared")), NewSigning([]byte("own"))
+	a := &Action{
+		DeliveryMode: DeliveryModeFallback,
+		Retries:      2,
+		Sign:         shared,
+		Upstreams: []Upstream{
+			{URL: "http://analytics", DeliveryMode: DeliveryModeQueued, Sign: own},
+			{URL: "http://payments", Primary: true},
+		},
+	}
+	assert.NoError(t, a.Validate())
+	primary, others = a.Targets()
+	assert.Equal(t, "http://payments", primary.UpstreamHost)
+	assert.Equal(t, DeliveryModeFallback, primary.DeliveryMode)
+	assert.Equal(t, 2, primary.Retries)
+	assert.Len(t, others, 1)
+	assert.Equal(t, "http://analytics", others[0].UpstreamHost)
+	assert.Equal(t, DeliveryModeQueued, others[0].DeliveryMode)
+	assert.Same(t, shared, primary.Sign)
+	assert.Same(t, own, others[0].Sign)
+
+	a.Upstreams[0].Primary = true
+	assert.ErrorIs(t, a.Validate(), ErrInvalidAction)
+
+	a.Upstreams[0].Primary = false
+	a.UpstreamHost = "http://a"
+	assert.ErrorIs(t, a.Validate(), ErrInvalidAction)
+}
+
+func TestAction_Mirrored(t *testing.T) {
+	assert.Nil(t, (&Action{UpstreamHost: "http://a"}).Mirrored())
+
+	a := &Action{
+		DeliveryMode: DeliveryModeQueued,
+		Retries:      3,
+		Mirror:       "http://shadow",
+		Upstreams:    []Upstream{{URL: "http://a"}, {URL: "http://b"}},
+	}
+	m := a.Mirrored()
+	assert.Equal(t, "http://shadow", m.UpstreamHost)
+	assert.Equal(t, DeliveryModeInstant, m.DeliveryMode)
+	assert.Zero(t, m.Retries)
+	assert.Empty(t, m.Upstreams)
+	assert.Empty(t, m.Mirror)
+
+	primary, others := a.Targets()
+	assert.Empty(t, primary.Mirror)
+	assert.Empty(t, others[0].Mirror)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
 	"strings"
 )
 
@@ -13,10 +15,13 @@ const (
 	NotEqual    = "not_equal"
 	Contains    = "contains"
 	NotContains = "not_contains"
+	Matches     = "matches"
+	Glob        = "glob"
 )
 
 var (
 	ErrUnsupportedComparator = errors.New("unsupported comparator")
+	ErrInvalidPattern        = errors.New("invalid pattern")
 )
 
 type Comparator interface {
@@ -29,6 +34,9 @@ func (c *ComparatorEqual) Compare(target PropertyValue, value interface{}) (bool
 	switch v := value.(type) {
 	case string:
 		return target.Value == v, nil
+	case JSONBody:
+		s, ok := v.Lookup(target.Key)
+		return ok && s == target.Value, nil
 	default:
 		return false, fmt.Errorf("%w for %v", ErrUnsupportedComparator, value)
 	}
@@ -40,6 +48,9 @@ func (c *ComparatorNotEqual) Compare(target PropertyValue, value interface{}) (b
 	switch v := value.(type) {
 	case string:
 		return target.Value != v, nil
+	case JSONBody:
+		s, ok := v.Lookup(target.Key)
+		return !ok || s != target.Value, nil
 	default:
 		return false, fmt.Errorf("%w for %v", ErrUnsupportedComparator, value)
 	}
@@ -57,6 +68,9 @@ func (c *ComparatorContains) Compare(target PropertyValue, value interface{}) (b
 	case http.Header:
 		headers := map[string][]string(v)
 		return checkValues(headers, target.Key, target.Value), nil
+	case JSONBody:
+		s, ok := v.Lookup(target.Key)
+		return ok && strings.Contains(s, target.Value), nil
 	default:
 		return false, ErrUnsupportedComparator
 	}
@@ -72,11 +86,76 @@ func (c *ComparatorNotContains) Compare(target PropertyValue, value interface{})
 		return !checkValues(v, target.Key, target.Value), nil
 	case http.Header:
 		return !checkValues(v, target.Key, target.Value), nil
+	case JSONBody:
+		s, ok := v.Lookup(target.Key)
+		return !ok || !strings.Contains(s, target.Value), nil
 	default:
 		return false, ErrUnsupportedComparator
 	}
 }
 
+// ComparatorMatches matches values against an RE2 regular expression.
+type ComparatorMatches struct {
+	re *regexp.Regexp
+}
+
+func NewComparatorMatches(pattern string) (*ComparatorMatches, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+	}
+	return &ComparatorMatches{re: re}, nil
+}
+
+func (c *ComparatorMatches) Compare(target PropertyValue, value interface{}) (bool, error) {
+	return comparePattern(c.re.MatchString, target, value)
+}
+
+// ComparatorGlob matches values against a shell glob as understood by
+// path.Match, so `*` does not cross a `/`.
+type ComparatorGlob struct {
+	pattern string
+}
+
+func NewComparatorGlob(pattern string) (*ComparatorGlob, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+	}
+	return &ComparatorGlob{pattern: pattern}, nil
+}
+
+func (c *ComparatorGlob) Compare(target PropertyValue, value interface{}) (bool, error) {
+	return comparePattern(func(s string) bool {
+		ok, _ := path.Match(c.pattern, s)
+		return ok
+	}, target, value)
+}
+
+func comparePattern(match func(string) bool, target PropertyValue, value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case string:
+		return match(v), nil
+	case url.Values:
+		return matchValues(v, target.Key, match), nil
+	case http.Header:
+		return matchValues(v, target.Key, match), nil
+	case JSONBody:
+		s, ok := v.Lookup(target.Key)
+		return ok && match(s), nil
+	default:
+		return false, fmt.Errorf("%w for %v", ErrUnsupportedComparator, value)
+	}
+}
+
+func matchValues(m map[string][]string, key string, match func(string) bool) bool {
+	for _, y := range m[key] {
+		if match(y) {
+			return true
+		}
+	}
+	return false
+}
+
 func checkValues(m map[string][]string, key, val string) bool {
 	for _, y := range m[key] {
 		if y == val {
@@ -136,3 +136,66 @@ func TestComparatorNotContains_Compare(t *testing.T) {
 	assert.False(t, result, "Expected false for unsupported type")
 	assert.Equal(t, ErrUnsupportedComparator, err, "Expected ErrUnsupportedComparator error for unsupported type")
 }
+
+func TestComparatorMatches_Compare(t *testing.T) {
+	c, err := NewComparatorMatches(`^/hooks/[^/]+/github$`)
+	assert.NoError(t, err)
+
+	// 1. Test for string match and mismatch
+	result, err := c.Compare(PropertyValue{}, "/hooks/acme/github")
+	assert.True(t, result, "Expected path to match pattern")
+	assert.Nil(t, err)
+
+	result, err = c.Compare(PropertyValue{}, "/hooks/acme/gitlab")
+	assert.False(t, result, "Expected path to not match pattern")
+	assert.Nil(t, err)
+
+	// 2. Test for http.Header match on any value of the key
+	c, err = NewComparatorMatches(`^push|pull_request$`)
+	assert.NoError(t, err)
+	target := PropertyValue{Key: "X-Github-Event", Value: "^push|pull_request$"}
+	result, err = c.Compare(target, http.Header{"X-Github-Event": []string{"issues", "push"}})
+	assert.True(t, result, "Expected header value to match pattern")
+	assert.Nil(t, err)
+
+	// 3. Test for url.Values mismatch
+	target = PropertyValue{Key: "event", Value: "^push|pull_request$"}
+	result, err = c.Compare(target, url.Values{"event": []string{"issues"}})
+	assert.False(t, result, "Expected query value to not match pattern")
+	assert.Nil(t, err)
+
+	// 4. Test for unsupported type
+	result, err = c.Compare(target, 123)
+	assert.False(t, result)
+	assert.ErrorIs(t, err, ErrUnsupportedComparator)
+
+	// 5. Test for invalid pattern
+	_, err = NewComparatorMatches(`(`)
+	assert.ErrorIs(t, err, ErrInvalidPattern)
+}
+
+func TestComparatorGlob_Compare(t *testing.T) {
+	c, err := NewComparatorGlob("/hooks/*/github")
+	assert.NoError(t, err)
+
+	// 1. Test for string match and mismatch
+	result, err := c.Compare(PropertyValue{}, "/hooks/acme/github")
+	assert.True(t, result, "Expected path to match glob")
+	assert.Nil(t, err)
+
+	result, err = c.Compare(PropertyValue{}, "/hooks/acme/sub/github")
+	assert.False(t, result, "Expected glob star to not cross a slash")
+	assert.Nil(t, err)
+
+	// 2. Test for JSON body match
+	c, err = NewComparatorGlob("customer.*")
+	assert.NoError(t, err)
+	target := PropertyValue{Key: "$.type", Value: "customer.*"}
+	result, err = c.Compare(target, JSONBody{Document: map[string]interface{}{"type": "customer.created"}})
+	assert.True(t, result, "Expected body field to match glob")
+	assert.Nil(t, err)
+
+	// 3. Test for invalid pattern
+	_, err = NewComparatorGlob("[")
+	assert.ErrorIs(t, err, ErrInvalidPattern)
+}
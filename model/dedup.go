@@ -0,0 +1,55 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultDedupTTL is how long a delivery is remembered when no TTL is set.
+const defaultDedupTTL = 24 * time.Hour
+
+var ErrInvalidDedup = errors.New("invalid dedup")
+
+// Dedup suppresses redeliveries of the same webhook, identified by Key, for
+// TTL seconds. Duplicates are answered with the status of the first delivery.
+type Dedup struct {
+	Key RequestKey `yaml:"key"`
+	TTL int        `yaml:"ttl"`
+}
+
+func (d *Dedup) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Dedup
+	if err := unmarshal((*plain)(d)); err != nil {
+		return err
+	}
+	return d.Validate()
+}
+
+func (d *Dedup) Validate() error {
+	if err := d.Key.Validate(); err != nil {
+		return fmt.Errorf("%w: key: %w", ErrInvalidDedup, err)
+	}
+	if d.TTL < 0 {
+		return fmt.Errorf("%w: negative ttl", ErrInvalidDedup)
+	}
+	return nil
+}
+
+func (d *Dedup) Expiry() time.Duration {
+	if d.TTL == 0 {
+		return defaultDedupTTL
+	}
+	return time.Duration(d.TTL) * time.Second
+}
+
+// IdempotencyKey returns the key identifying req, scoped by prefix, false
+// when the request does not carry one.
+func (d *Dedup) IdempotencyKey(prefix string, req *http.Request) (string, bool) {
+	v, ok := d.Key.Value(req)
+	if !ok || v == "" {
+		return "", false
+	}
+	return prefix + "\x00" + v, true
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDedup_UnmarshalYAML(t *testing.T) {
+	d := &Dedup{}
+	require.NoError(t, yaml.Unmarshal([]byte("key:\n  header: X-GitHub-Delivery"), d))
+	assert.Equal(t, defaultDedupTTL, d.Expiry())
+
+	d = &Dedup{}
+	require.NoError(t, yaml.Unmarshal([]byte("key:\n  body: $.id\nttl: 60"), d))
+	assert.Equal(t, time.Minute, d.Expiry())
+
+	assert.ErrorIs(t, yaml.Unmarshal([]byte("ttl: 60"), &Dedup{}), ErrInvalidDedup)
+	assert.ErrorIs(t, yaml.Unmarshal([]byte("key:\n  header: X-Id\nttl: -1"), &Dedup{}), ErrInvalidDedup)
+}
+
+func TestDedup_IdempotencyKey(t *testing.T) {
+	d := &Dedup{Key: RequestKey{Header: "X-GitHub-Delivery"}}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	_, ok := d.IdempotencyKey("github", req)
+	assert.False(t, ok)
+
+	req.Header.Set("X-GitHub-Delivery", "72d3162e")
+	key, ok := d.IdempotencyKey("github", req)
+	assert.True(t, ok)
+	assert.Equal(t, "github\x0072d3162e", key)
+}
@@ -4,4 +4,5 @@ import "errors"
 
 var (
 	ErrUnknownDeliveryMode = errors.New("unknown delivery mode")
+	ErrInvalidAction       = errors.New("invalid action")
 )
@@ -0,0 +1,60 @@
+package model
+
+import "net/http"
+
+// Explanation records how a trigger set evaluated a request. Unlike Match,
+// every member is evaluated so the whole tree can be inspected.
+type Explanation struct {
+	Operator    Operator
+	Matched     bool
+	Triggers    []TriggerResult
+	TriggerSets []Explanation
+}
+
+type TriggerResult struct {
+	Trigger Trigger
+	Matched bool
+	Error   string `json:",omitempty"`
+}
+
+func (ts *TriggerSet) Explain(req *http.Request) Explanation {
+	e := Explanation{Operator: ts.Operator}
+	if e.Operator == "" {
+		e.Operator = OperatorAnd
+	}
+
+	var results []bool
+	for i := range ts.Triggers {
+		matched, err := ts.Triggers[i].Match(req)
+		r := TriggerResult{Trigger: ts.Triggers[i], Matched: matched}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		e.Triggers = append(e.Triggers, r)
+		results = append(results, matched)
+	}
+	for i := range ts.TriggerSets {
+		child := ts.TriggerSets[i].Explain(req)
+		e.TriggerSets = append(e.TriggerSets, child)
+		results = append(results, child.Matched)
+	}
+
+	switch e.Operator {
+	case OperatorOr:
+		e.Matched = false
+		for _, r := range results {
+			e.Matched = e.Matched || r
+		}
+	case OperatorNot:
+		e.Matched = true
+		for _, r := range results {
+			e.Matched = e.Matched && !r
+		}
+	default:
+		e.Matched = true
+		for _, r := range results {
+			e.Matched = e.Matched && r
+		}
+	}
+	return e
+}
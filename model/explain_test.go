@@ -0,0 +1,42 @@
+package model
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTriggerSet_Explain(t *testing.T) {
+	ts := &TriggerSet{
+		Triggers: []Trigger{
+			{Property: PropertyPath, comparator: &ComparatorEqual{}, Value: PropertyValue{Value: "/test"}},
+			{Property: PropertyMethod, comparator: &ComparatorEqual{}, Value: PropertyValue{Value: "GET"}},
+		},
+		TriggerSets: []TriggerSet{
+			{
+				Triggers: []Trigger{
+					{Property: PropertyHeader, comparator: &ComparatorEqual{}, Value: PropertyValue{Key: "A", Value: "1"}},
+				},
+				Operator: OperatorNot,
+			},
+		},
+	}
+
+	req := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/test"}, Header: http.Header{}}
+	e := ts.Explain(req)
+
+	assert.Equal(t, OperatorAnd, e.Operator)
+	assert.False(t, e.Matched)
+	assert.Len(t, e.Triggers, 2, "Expected every trigger to be evaluated")
+	assert.True(t, e.Triggers[0].Matched)
+	assert.False(t, e.Triggers[1].Matched)
+
+	assert.Len(t, e.TriggerSets, 1)
+	assert.True(t, e.TriggerSets[0].Matched)
+	assert.NotEmpty(t, e.TriggerSets[0].Triggers[0].Error)
+
+	matched, _ := ts.Match(req)
+	assert.Equal(t, matched, e.Matched)
+}
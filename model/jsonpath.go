@@ -0,0 +1,112 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidJSONPath = errors.New("invalid json path")
+)
+
+// JSONBody is the parsed JSON payload of a request. Document is nil when the
+// body is empty or is not valid JSON.
+type JSONBody struct {
+	Document interface{}
+}
+
+// Lookup resolves a JSONPath-style expression such as `$.event.type` or
+// `$.items[0].id` against the document and returns the value as a string.
+// Scalars are formatted as-is, objects and arrays as compact JSON.
+func (b JSONBody) Lookup(path string) (string, bool) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return "", false
+	}
+
+	current := b.Document
+	for _, s := range segments {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			v, ok := node[s]
+			if !ok {
+				return "", false
+			}
+			current = v
+		case []interface{}:
+			i, err := strconv.Atoi(s)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			current = node[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := current.(type) {
+	case nil:
+		return "null", true
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		out, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(out), true
+	}
+}
+
+// parseJSONPath splits a path into its member and index segments. The leading
+// `$` is optional; members may be written as `.name` or `['name']` and array
+// elements as `[0]`.
+func parseJSONPath(path string) ([]string, error) {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	if p == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidJSONPath, path)
+	}
+	if p[0] != '.' && p[0] != '[' {
+		p = "." + p
+	}
+
+	var segments []string
+	for len(p) > 0 {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidJSONPath, path)
+			}
+			segments = append(segments, p[:end])
+			p = p[end:]
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidJSONPath, path)
+			}
+			s := p[1:end]
+			if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+				s = s[1 : len(s)-1]
+			} else if _, err := strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidJSONPath, path)
+			}
+			segments = append(segments, s)
+			p = p[end+1:]
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidJSONPath, path)
+		}
+	}
+	return segments, nil
+}
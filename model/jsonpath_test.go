@@ -0,0 +1,60 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONBody_Lookup(t *testing.T) {
+	var doc interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"event": {"type": "push", "id": 42, "live": true, "meta": null},
+		"items": [{"id": "a"}, {"id": "b"}],
+		"dotted.key": "x"
+	}`), &doc))
+	body := JSONBody{Document: doc}
+
+	tests := []struct {
+		path      string
+		wantValue string
+		wantOK    bool
+	}{
+		{path: "$.event.type", wantValue: "push", wantOK: true},
+		{path: "event.type", wantValue: "push", wantOK: true},
+		{path: "$.event.id", wantValue: "42", wantOK: true},
+		{path: "$.event.live", wantValue: "true", wantOK: true},
+		{path: "$.event.meta", wantValue: "null", wantOK: true},
+		{path: "$.items[1].id", wantValue: "b", wantOK: true},
+		{path: "$['dotted.key']", wantValue: "x", wantOK: true},
+		{path: "$.items[0]", wantValue: `{"id":"a"}`, wantOK: true},
+		{path: "$.items[2].id", wantOK: false},
+		{path: "$.event.missing", wantOK: false},
+		{path: "$.event.type.deeper", wantOK: false},
+		{path: "$..type", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := body.Lookup(tt.path)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantValue, got)
+		})
+	}
+
+	_, ok := JSONBody{}.Lookup("$.event.type")
+	assert.False(t, ok, "Expected lookup on empty body to fail")
+}
+
+func TestParseJSONPath(t *testing.T) {
+	segments, err := parseJSONPath("$.a['b.c'][3].d")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b.c", "3", "d"}, segments)
+
+	for _, p := range []string{"", "$", "$.", "$.a[", "$.a[x]", "$..a"} {
+		_, err := parseJSONPath(p)
+		assert.ErrorIs(t, err, ErrInvalidJSONPath, p)
+	}
+}
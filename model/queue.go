@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 type QueuedRequest struct {
@@ -25,3 +26,39 @@ type PubSub interface {
 	Publisher
 	Consumer
 }
+
+// DelayedPublisher is implemented by queues that can hold a message back
+// until delay has passed, without a consumer waiting for it.
+type DelayedPublisher interface {
+	PublishDelayed(ctx context.Context, payload []byte, delay time.Duration) error
+}
+
+// Pinger is implemented by queues that can report whether they are usable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// DeadLetter is a queued delivery that was given up on, together with the
+// reason why. Payload holds the queued request as it was last attempted.
+type DeadLetter struct {
+	RequestID  string
+	Rule       string
+	Attempts   int
+	LastStatus int
+	Error      string
+	FailedAt   time.Time
+	Payload    []byte
+}
+
+type DeadLetterPublisher interface {
+	PublishDeadLetter(ctx context.Context, dl *DeadLetter) error
+}
+
+type DeadLetterConsumer interface {
+	StartDeadLetterConsumer(context.Context, func(dl *DeadLetter) error) error
+}
+
+type DeadLetterQueue interface {
+	DeadLetterPublisher
+	DeadLetterConsumer
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// What happens to inbound requests over a rule's rate limit.
+const (
+	RateLimitReject = "reject"
+	RateLimitQueue  = "queue"
+)
+
+var ErrInvalidRateLimit = errors.New("invalid rate limit")
+
+// RateLimit is a token bucket refilled at Rate requests per second and
+// holding up to Burst, which defaults to Rate rounded up. With a Key, each
+// distinct value gets its own bucket.
+//
+// On a rule it limits inbound requests, which are rejected with 429 or, when
+// Exceeded is "queue", delivered through the queue instead. On an action or
+// upstream, which must then be delivered queued, it limits outbound deliveries
+// of the rule from the queue to each host; deliveries over the limit are
+// postponed.
+type RateLimit struct {
+	Rate     float64     `yaml:"rate"`
+	Burst    int         `yaml:"burst"`
+	Key      *RequestKey `yaml:"key"`
+	Exceeded string      `yaml:"exceeded"`
+}
+
+func (r *RateLimit) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RateLimit
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	return r.Validate()
+}
+
+func (r *RateLimit) Validate() error {
+	if r.Rate <= 0 {
+		return fmt.Errorf("%w: rate must be positive", ErrInvalidRateLimit)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("%w: burst must not be negative", ErrInvalidRateLimit)
+	}
+	if r.Burst == 0 {
+		r.Burst = int(math.Ceil(r.Rate))
+	}
+	switch r.Exceeded {
+	case "", RateLimitReject, RateLimitQueue:
+	default:
+		return fmt.Errorf("%w: unknown exceeded %q", ErrInvalidRateLimit, r.Exceeded)
+	}
+	if r.Key != nil {
+		if err := r.Key.Validate(); err != nil {
+			return fmt.Errorf("%w: key: %w", ErrInvalidRateLimit, err)
+		}
+	}
+	return nil
+}
+
+// Bucket returns the name of the bucket req draws from, scoped by prefix.
+// Requests lacking the key share the bucket of the empty value.
+func (r *RateLimit) Bucket(prefix string, req *http.Request) string {
+	if r.Key == nil {
+		return prefix
+	}
+	v, _ := r.Key.Value(req)
+	return prefix + "\x00" + v
+}
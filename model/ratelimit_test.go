@@ -0,0 +1,55 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRateLimit_UnmarshalYAML(t *testing.T) {
+	rl := &RateLimit{}
+	require.NoError(t, yaml.Unmarshal([]byte("rate: 2.5\nexceeded: queue"), rl))
+	assert.Equal(t, 3, rl.Burst)
+
+	for _, doc := range []string{
+		"burst: 1",
+		"rate: 1\nburst: -1",
+		"rate: 1\nexceeded: drop",
+		"rate: 1\nkey: {}",
+	} {
+		err := yaml.Unmarshal([]byte(doc), &RateLimit{})
+		assert.ErrorIs(t, err, ErrInvalidRateLimit, doc)
+	}
+
+	err := yaml.Unmarshal([]byte("upstream: http://a\nrate_limit:\n  rate: 1\n  exceeded: queue"), &Action{})
+	assert.ErrorIs(t, err, ErrInvalidAction)
+}
+
+func TestRateLimit_Bucket(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"shop":"acme"}`))
+	req.Header.Set("X-Shopify-Shop-Domain", "acme.myshopify.com")
+
+	assert.Equal(t, "rule", (&RateLimit{}).Bucket("rule", req))
+	assert.Equal(t, "rule\x00acme.myshopify.com", (&RateLimit{Key: &RequestKey{Header: "X-Shopify-Shop-Domain"}}).Bucket("rule", req))
+	assert.Equal(t, "rule\x00acme", (&RateLimit{Key: &RequestKey{Body: "$.shop"}}).Bucket("rule", req))
+	assert.Equal(t, "rule\x00", (&RateLimit{Key: &RequestKey{Header: "X-Missing"}}).Bucket("rule", req))
+}
+
+func TestAction_Queued(t *testing.T) {
+	a := &Action{
+		DeliveryMode: DeliveryModeInstant,
+		Mirror:       "http://shadow",
+		Upstreams:    []Upstream{{URL: "http://a", DeliveryMode: DeliveryModeFallback}, {URL: "http://b"}},
+	}
+	q := a.Queued()
+	primary, others := q.Targets()
+	assert.Equal(t, DeliveryModeQueued, primary.DeliveryMode)
+	assert.Equal(t, DeliveryModeQueued, others[0].DeliveryMode)
+	assert.Nil(t, q.Mirrored())
+	assert.Equal(t, DeliveryModeFallback, a.Upstreams[0].DeliveryMode, "the original action is unchanged")
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrInvalidRequestKey = errors.New("invalid request key")
+
+// RequestKey picks a value out of a request to group requests by, e.g. the
+// tenant of a webhook. Exactly one of Header or Body, a JSON path, is set.
+type RequestKey struct {
+	Header string `yaml:"header"`
+	Body   string `yaml:"body"`
+}
+
+func (k *RequestKey) Validate() error {
+	if (k.Header == "") == (k.Body == "") {
+		return fmt.Errorf("%w: needs exactly one of header or body", ErrInvalidRequestKey)
+	}
+	if k.Body != "" {
+		if _, err := parseJSONPath(k.Body); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequestKey, err)
+		}
+	}
+	return nil
+}
+
+// Value returns the key of req, false when the request lacks it.
+func (k *RequestKey) Value(req *http.Request) (string, bool) {
+	if k.Header != "" {
+		v := req.Header.Get(k.Header)
+		return v, v != ""
+	}
+	return parseBody(req).Lookup(k.Body)
+}
@@ -1,7 +1,71 @@
 package model
 
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidRule = errors.New("invalid rule")
+)
+
 type Rule struct {
-	Name       string      `yaml:"name"`
-	TriggerSet *TriggerSet `yaml:"triggerset"`
-	Action     *Action     `yaml:"action"`
+	Name       string        `yaml:"name"`
+	TriggerSet *TriggerSet   `yaml:"triggerset"`
+	Verify     *Verification `yaml:"verify"`
+	Dedup      *Dedup        `yaml:"dedup"`
+	RateLimit  *RateLimit    `yaml:"rate_limit"`
+	Action     *Action       `yaml:"action"`
+}
+
+// Validate checks what cannot be checked while parsing a single rule: that it
+// is complete and that every target it delivers to is usable.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidRule)
+	}
+	if r.TriggerSet == nil {
+		return fmt.Errorf("%w %q: missing triggerset", ErrInvalidRule, r.Name)
+	}
+	if r.Action == nil {
+		return fmt.Errorf("%w %q: missing action", ErrInvalidRule, r.Name)
+	}
+
+	primary, others := r.Action.Targets()
+	if m := r.Action.Mirrored(); m != nil {
+		others = append(others, m)
+	}
+	for _, t := range append([]*Action{primary}, others...) {
+		switch t.DeliveryMode {
+		case DeliveryModeInstant, DeliveryModeQueued, DeliveryModeFallback:
+		default:
+			return fmt.Errorf("%w %q: %w %q", ErrInvalidRule, r.Name, ErrUnknownDeliveryMode, t.DeliveryMode)
+		}
+		if u := t.URL(); u == nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w %q: invalid upstream %q", ErrInvalidRule, r.Name, t.UpstreamHost)
+		}
+		if t.RateLimit != nil && t.DeliveryMode != DeliveryModeQueued {
+			return fmt.Errorf("%w %q: %w: only queued deliveries are limited, not %s ones", ErrInvalidRule, r.Name, ErrInvalidRateLimit, t.DeliveryMode)
+		}
+		queued := t.DeliveryMode != DeliveryModeInstant || (r.RateLimit != nil && r.RateLimit.Exceeded == RateLimitQueue)
+		if queued && t.Sign != nil && t.Sign.SecretEnv == "" {
+			return fmt.Errorf("%w %q: %w", ErrInvalidRule, r.Name, ErrInlineSecret)
+		}
+	}
+	return nil
+}
+
+// ValidateRules validates each rule and that rule names are unique.
+func ValidateRules(rules []Rule) error {
+	seen := make(map[string]bool, len(rules))
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+		if seen[rules[i].Name] {
+			return fmt.Errorf("%w %q: duplicate name", ErrInvalidRule, rules[i].Name)
+		}
+		seen[rules[i].Name] = true
+	}
+	return nil
 }
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRule_Validate(t *testing.T) {
+	valid := func() Rule {
+		return Rule{
+			Name:       "rule_1",
+			TriggerSet: &TriggerSet{},
+			Action:     &Action{UpstreamHost: "http://localhost:8000", DeliveryMode: DeliveryModeInstant},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(r *Rule)
+		wantErr error
+	}{
+		{name: "valid rule", mutate: func(r *Rule) {}},
+		{name: "missing name", mutate: func(r *Rule) { r.Name = "" }, wantErr: ErrInvalidRule},
+		{name: "missing triggerset", mutate: func(r *Rule) { r.TriggerSet = nil }, wantErr: ErrInvalidRule},
+		{name: "missing action", mutate: func(r *Rule) { r.Action = nil }, wantErr: ErrInvalidRule},
+		{name: "unknown delivery mode", mutate: func(r *Rule) { r.Action.DeliveryMode = "later" }, wantErr: ErrUnknownDeliveryMode},
+		{name: "relative upstream", mutate: func(r *Rule) { r.Action.UpstreamHost = "/hooks" }, wantErr: ErrInvalidRule},
+		{name: "relative mirror", mutate: func(r *Rule) { r.Action.Mirror = "shadow" }, wantErr: ErrInvalidRule},
+		{
+			name: "unknown delivery mode on secondary upstream",
+			mutate: func(r *Rule) {
+				r.Action.UpstreamHost = ""
+				r.Action.Upstreams = []Upstream{{URL: "http://a"}, {URL: "http://b", DeliveryMode: "later"}}
+			},
+			wantErr: ErrUnknownDeliveryMode,
+		},
+		{name: "inline signing secret delivered instantly", mutate: func(r *Rule) { r.Action.Sign = NewSigning([]byte("s")) }},
+		{
+			name: "inline signing secret queued",
+			mutate: func(r *Rule) {
+				r.Action.Sign = NewSigning([]byte("s"))
+				r.Action.DeliveryMode = DeliveryModeFallback
+			},
+			wantErr: ErrInlineSecret,
+		},
+		{
+			name: "inline signing secret queued over the rate limit",
+			mutate: func(r *Rule) {
+				r.Action.Sign = NewSigning([]byte("s"))
+				r.RateLimit = &RateLimit{Rate: 1, Exceeded: RateLimitQueue}
+			},
+			wantErr: ErrInlineSecret,
+		},
+		{
+			name: "rate limited instant upstream",
+			mutate: func(r *Rule) {
+				r.Action.RateLimit = &RateLimit{Rate: 1}
+			},
+			wantErr: ErrInvalidRateLimit,
+		},
+		{
+			name: "rate limited fallback upstream",
+			mutate: func(r *Rule) {
+				r.Action.DeliveryMode = DeliveryModeFallback
+				r.Action.Upstreams = []Upstream{{URL: "http://localhost:8001", RateLimit: &RateLimit{Rate: 1}}}
+				r.Action.UpstreamHost = ""
+			},
+			wantErr: ErrInvalidRateLimit,
+		},
+		{
+			name: "rate limited queued upstream with a mirror",
+			mutate: func(r *Rule) {
+				r.Action.DeliveryMode = DeliveryModeQueued
+				r.Action.RateLimit = &RateLimit{Rate: 1}
+				r.Action.Mirror = "http://localhost:8002"
+			},
+		},
+		{
+			name: "signing secret_env queued",
+			mutate: func(r *Rule) {
+				r.Action.Sign = &Signing{SecretEnv: "HOOKIE_SIGNING_SECRET"}
+				r.Action.DeliveryMode = DeliveryModeQueued
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.mutate(&r)
+			err := r.Validate()
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	rule := Rule{
+		Name:       "rule_1",
+		TriggerSet: &TriggerSet{},
+		Action:     &Action{UpstreamHost: "http://localhost:8000", DeliveryMode: DeliveryModeQueued},
+	}
+	assert.NoError(t, ValidateRules([]Rule{rule}))
+	assert.ErrorIs(t, ValidateRules([]Rule{rule, rule}), ErrInvalidRule)
+}
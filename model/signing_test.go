@@ -0,0 +1,41 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSigning_UnmarshalYAML(t *testing.T) {
+	s := &Signing{}
+	require.NoError(t, yaml.Unmarshal([]byte("secret: whsec_aGVsbG8="), s))
+	assert.Equal(t, []byte("hello"), s.Key())
+
+	t.Setenv("HOOKIE_TEST_SIGNING_SECRET", "plain")
+	s = &Signing{}
+	require.NoError(t, yaml.Unmarshal([]byte("secret_env: HOOKIE_TEST_SIGNING_SECRET"), s))
+	assert.Equal(t, []byte("plain"), s.Key())
+
+	assert.ErrorIs(t, yaml.Unmarshal([]byte("secret_env: HOOKIE_TEST_UNSET"), &Signing{}), ErrInvalidSigning)
+	assert.ErrorIs(t, yaml.Unmarshal([]byte("secret: whsec_!!"), &Signing{}), ErrInvalidSigning)
+}
+
+func TestSigning_Sign(t *testing.T) {
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	timeNow = func() time.Time { return time.Unix(1614265330, 0) }
+
+	// Test vector from the Standard Webhooks specification.
+	s := &Signing{Secret: "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"}
+	require.NoError(t, s.Validate())
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	s.Sign(req, "msg_p5jXN8AQM9LWM0D4loKWxJek", []byte(`{"test": 2432232314}`))
+
+	assert.Equal(t, "msg_p5jXN8AQM9LWM0D4loKWxJek", req.Header.Get(HeaderWebhookID))
+	assert.Equal(t, "1614265330", req.Header.Get(HeaderWebhookTimestamp))
+	assert.Equal(t, "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=", req.Header.Get(HeaderWebhookSignature))
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+)
+
+var weightedChoice = rand.Intn
+
+// Weighted reports whether the action splits traffic between its upstreams
+// instead of fanning out to all of them.
+func (a *Action) Weighted() bool {
+	for _, u := range a.Upstreams {
+		if u.Weight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Selection records which weighted upstream a request was assigned to.
+type Selection struct {
+	Upstream string
+	Sticky   bool
+}
+
+// Pick chooses one upstream of a weighted action, proportionally to the
+// weights, and returns the action delivering to it alone. Actions that are
+// not weighted are returned as is.
+func (a *Action) Pick(req *http.Request) (*Action, Selection) {
+	if !a.Weighted() {
+		return a, Selection{}
+	}
+
+	total := 0
+	for _, u := range a.Upstreams {
+		total += u.Weight
+	}
+
+	sel := Selection{}
+	var point int
+	if k, ok := a.stickyKey(req); ok {
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		point = int(h.Sum64() % uint64(total))
+		sel.Sticky = true
+	} else {
+		point = weightedChoice(total)
+	}
+
+	chosen := 0
+	for i, u := range a.Upstreams {
+		if point < u.Weight {
+			chosen = i
+			break
+		}
+		point -= u.Weight
+	}
+
+	u := a.Upstreams[chosen]
+	sel.Upstream = u.Name
+	if sel.Upstream == "" {
+		sel.Upstream = u.URL
+	}
+	return a.target(u), sel
+}
+
+func (a *Action) stickyKey(req *http.Request) (string, bool) {
+	if a.Sticky == nil {
+		return "", false
+	}
+	return a.Sticky.Value(req)
+}
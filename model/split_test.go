@@ -0,0 +1,97 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestAction_Pick(t *testing.T) {
+	a := &Action{}
+	require.NoError(t, yaml.Unmarshal([]byte(`delivery_mode: instant
+upstreams:
+  - name: v1
+    url: http://v1
+    weight: 95
+  - name: v2
+    url: http://v2
+    weight: 5
+    delivery_mode: queued
+`), a))
+	assert.True(t, a.Weighted())
+
+	defer func(orig func(int) int) { weightedChoice = orig }(weightedChoice)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	weightedChoice = func(n int) int { return 94 }
+	target, sel := a.Pick(req)
+	assert.Equal(t, "http://v1", target.UpstreamHost)
+	assert.Equal(t, DeliveryModeInstant, target.DeliveryMode)
+	assert.Equal(t, Selection{Upstream: "v1"}, sel)
+
+	weightedChoice = func(n int) int { return 95 }
+	target, sel = a.Pick(req)
+	assert.Equal(t, "http://v2", target.UpstreamHost)
+	assert.Equal(t, DeliveryModeQueued, target.DeliveryMode)
+	assert.Equal(t, "v2", sel.Upstream)
+	primary, others := target.Targets()
+	assert.Same(t, target, primary)
+	assert.Empty(t, others)
+
+	unweighted := &Action{UpstreamHost: "http://a"}
+	target, sel = unweighted.Pick(req)
+	assert.Same(t, unweighted, target)
+	assert.Empty(t, sel.Upstream)
+}
+
+func TestAction_PickSticky(t *testing.T) {
+	a := &Action{}
+	require.NoError(t, yaml.Unmarshal([]byte(`upstreams:
+  - url: http://v1
+    weight: 50
+  - url: http://v2
+    weight: 50
+sticky:
+  body: $.account.id
+`), a))
+
+	defer func(orig func(int) int) { weightedChoice = orig }(weightedChoice)
+	weightedChoice = func(n int) int { t.Fatal("sticky requests must not be picked at random"); return 0 }
+
+	assigned := map[string]string{}
+	for i := 0; i < 20; i++ {
+		for _, account := range []string{"acme", "globex", "initech"} {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"account":{"id":"`+account+`"}}`))
+			_, sel := a.Pick(req)
+			assert.True(t, sel.Sticky)
+			if prev, ok := assigned[account]; ok {
+				assert.Equal(t, prev, sel.Upstream, account)
+			}
+			assigned[account] = sel.Upstream
+		}
+	}
+
+	// Requests without the key fall back to a random choice.
+	weightedChoice = func(n int) int { return 0 }
+	_, sel := a.Pick(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`)))
+	assert.Equal(t, Selection{Upstream: "http://v1"}, sel)
+}
+
+func TestAction_ValidateWeighted(t *testing.T) {
+	for _, doc := range []string{
+		"upstreams:\n  - url: http://a\n    weight: -1",
+		"upstreams:\n  - url: http://a\n    weight: 1\n    primary: true",
+		"upstream: http://a\nsticky:\n  header: X-Account",
+		"upstreams:\n  - url: http://a\n    weight: 1\nsticky:\n  header: X-Account\n  body: $.account",
+		"upstreams:\n  - url: http://a\n    weight: 1\nsticky: {}",
+		"upstreams:\n  - url: http://a\n    weight: 1\nsticky:\n  body: $..",
+	} {
+		err := yaml.Unmarshal([]byte(doc), &Action{})
+		assert.ErrorIs(t, err, ErrInvalidAction, doc)
+	}
+}
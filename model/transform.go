@@ -0,0 +1,255 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var ErrInvalidTransform = errors.New("invalid transform")
+
+// Transform rewrites a matched request before it is forwarded. Header and
+// query values are templates evaluated against the request as it was
+// received, see TemplateData. Body, when set, is a template rendering the new
+// JSON body.
+type Transform struct {
+	Method  string          `yaml:"method"`
+	Path    *PathTransform  `yaml:"path"`
+	Headers *ValueTransform `yaml:"headers"`
+	Query   *ValueTransform `yaml:"query"`
+	Body    string          `yaml:"body"`
+
+	body *template.Template
+}
+
+// PathTransform strips a prefix from the path, then replaces the matches of
+// Regex with Replacement, which may refer to capture groups as $1.
+type PathTransform struct {
+	StripPrefix string `yaml:"strip_prefix"`
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// ValueTransform edits a set of named values, headers or query parameters.
+// Remove is applied first, then Set replaces any existing values and Add
+// appends to them.
+type ValueTransform struct {
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+	Remove []string          `yaml:"remove"`
+
+	set map[string]*template.Template
+	add map[string]*template.Template
+}
+
+// templateFuncs are available to every transform template. json encodes a
+// value, so that strings pulled from the body are quoted and escaped.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// TemplateData is what transform templates are evaluated against, e.g.
+// {{ .Header "X-GitHub-Event" }}, {{ .Query "id" }},
+// {{ .Body.repository.full_name }} or {{ .Field "$.commits[0].id" }}.
+type TemplateData struct {
+	RequestID string
+	Method    string
+	Host      string
+	Path      string
+
+	header http.Header
+	query  url.Values
+	req    *http.Request
+	body   *JSONBody
+}
+
+func NewTemplateData(req *http.Request, requestID string) *TemplateData {
+	return &TemplateData{
+		RequestID: requestID,
+		Method:    req.Method,
+		Host:      req.Host,
+		Path:      req.URL.Path,
+		header:    req.Header,
+		query:     req.URL.Query(),
+		req:       req,
+	}
+}
+
+// Body returns the parsed JSON body, nil when it is not JSON. The body is
+// only read the first time it is needed.
+func (d *TemplateData) Body() interface{} {
+	if d.body == nil {
+		b := parseBody(d.req)
+		d.body = &b
+	}
+	return d.body.Document
+}
+
+// Field looks up a value in the JSON body with the same path syntax as body
+// triggers.
+func (d *TemplateData) Field(path string) string {
+	d.Body()
+	v, _ := d.body.Lookup(path)
+	return v
+}
+
+func (d *TemplateData) Header(name string) string {
+	return d.header.Get(name)
+}
+
+func (d *TemplateData) Query(name string) string {
+	return d.query.Get(name)
+}
+
+func (t *Transform) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Transform
+	if err := unmarshal((*plain)(t)); err != nil {
+		return err
+	}
+	return t.Validate()
+}
+
+// Validate compiles the path regex and the value templates.
+func (t *Transform) Validate() error {
+	if t.Path != nil && t.Path.Regex != "" {
+		re, err := regexp.Compile(t.Path.Regex)
+		if err != nil {
+			return fmt.Errorf("%w: path: %w", ErrInvalidTransform, err)
+		}
+		t.Path.re = re
+	}
+	if t.Body != "" {
+		tmpl, err := template.New("body").Funcs(templateFuncs).Parse(t.Body)
+		if err != nil {
+			return fmt.Errorf("%w: body: %w", ErrInvalidTransform, err)
+		}
+		t.body = tmpl
+	}
+	for name, v := range map[string]*ValueTransform{"headers": t.Headers, "query": t.Query} {
+		if v == nil {
+			continue
+		}
+		var err error
+		if v.set, err = parseTemplates(name, v.Set); err != nil {
+			return err
+		}
+		if v.add, err = parseTemplates(name, v.Add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply returns a copy of req with the transform applied. Unless it is
+// rewritten, the body is shared with req.
+func (t *Transform) Apply(req *http.Request, requestID string) (*http.Request, error) {
+	data := NewTemplateData(req, requestID)
+	out := req.Clone(req.Context())
+
+	if t.body != nil {
+		body, err := execute(t.body, data)
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid([]byte(body)) {
+			return nil, errors.New("body template rendered invalid JSON")
+		}
+		buf := []byte(body)
+		out.Body = io.NopCloser(bytes.NewReader(buf))
+		out.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		}
+		out.ContentLength = int64(len(buf))
+		out.Header.Set("Content-Type", "application/json")
+	}
+
+	if t.Method != "" {
+		out.Method = strings.ToUpper(t.Method)
+	}
+
+	if t.Path != nil {
+		p := strings.TrimPrefix(out.URL.Path, t.Path.StripPrefix)
+		if t.Path.re != nil {
+			p = t.Path.re.ReplaceAllString(p, t.Path.Replacement)
+		}
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		out.URL.Path, out.URL.RawPath = p, ""
+	}
+
+	if t.Headers != nil {
+		if err := t.Headers.apply(out.Header, http.CanonicalHeaderKey, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.Query != nil {
+		q := out.URL.Query()
+		if err := t.Query.apply(q, func(k string) string { return k }, data); err != nil {
+			return nil, err
+		}
+		out.URL.RawQuery = q.Encode()
+	}
+
+	// Reading the body for a template replaces req.Body with a fresh reader.
+	if t.body == nil {
+		out.Body = req.Body
+	}
+	return out, nil
+}
+
+// apply edits values in place. canonical normalizes the names, which header
+// names need and query parameter names must not get.
+func (v *ValueTransform) apply(values map[string][]string, canonical func(string) string, data *TemplateData) error {
+	for _, k := range v.Remove {
+		delete(values, canonical(k))
+	}
+	for k, tmpl := range v.set {
+		val, err := execute(tmpl, data)
+		if err != nil {
+			return err
+		}
+		values[canonical(k)] = []string{val}
+	}
+	for k, tmpl := range v.add {
+		val, err := execute(tmpl, data)
+		if err != nil {
+			return err
+		}
+		values[canonical(k)] = append(values[canonical(k)], val)
+	}
+	return nil
+}
+
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to execute template %q: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
+
+func parseTemplates(name string, values map[string]string) (map[string]*template.Template, error) {
+	out := make(map[string]*template.Template, len(values))
+	for k, v := range values {
+		tmpl, err := template.New(k).Funcs(templateFuncs).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %q: %w", ErrInvalidTransform, name, k, err)
+		}
+		out[k] = tmpl
+	}
+	return out, nil
+}
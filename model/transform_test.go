@@ -0,0 +1,108 @@
+package model
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestTransform_UnmarshalYAML(t *testing.T) {
+	for _, doc := range []string{
+		"path:\n  regex: '('",
+		"headers:\n  set:\n    X-Event: '{{ .Header'",
+		"query:\n  add:\n    id: '{{ end }}'",
+		"body: '{{ .Body'",
+	} {
+		err := yaml.Unmarshal([]byte(doc), &Transform{})
+		assert.ErrorIs(t, err, ErrInvalidTransform, doc)
+	}
+}
+
+func TestTransform_Apply(t *testing.T) {
+	tr := &Transform{}
+	require.NoError(t, yaml.Unmarshal([]byte(`method: put
+path:
+  strip_prefix: /hooks
+  regex: ^/(\w+)/v1$
+  replacement: /internal/$1
+headers:
+  set:
+    X-Event: '{{ .Header "X-GitHub-Event" }}'
+    X-Request-Id: '{{ .RequestID }}'
+  add:
+    X-Tag: hookie
+  remove: [cookie]
+query:
+  set:
+    source: '{{ .Method }} {{ .Query "Account" }}'
+  remove: [token]
+`), tr))
+
+	req := httptest.NewRequest(http.MethodPost, "/hooks/stripe/v1?token=secret&Account=acme", nil)
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Tag", "original")
+	req.Header.Set("Cookie", "session")
+
+	out, err := tr.Apply(req, "req-1")
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPut, out.Method)
+	assert.Equal(t, "/internal/stripe", out.URL.Path)
+	assert.Equal(t, "push", out.Header.Get("X-Event"))
+	assert.Equal(t, "req-1", out.Header.Get("X-Request-Id"))
+	assert.Equal(t, []string{"original", "hookie"}, out.Header.Values("X-Tag"))
+	assert.Empty(t, out.Header.Get("Cookie"))
+	assert.Equal(t, "Account=acme&source=POST+acme", out.URL.RawQuery)
+
+	// The original request is left untouched.
+	assert.Equal(t, "/hooks/stripe/v1", req.URL.Path)
+	assert.Equal(t, "session", req.Header.Get("Cookie"))
+}
+
+func TestTransform_ApplyBody(t *testing.T) {
+	tr := &Transform{}
+	require.NoError(t, yaml.Unmarshal([]byte(`body: |
+  {
+    "repository": {{ json .Body.repository.full_name }},
+    "commit": {{ json (.Field "$.commits[0].id") }},
+    "event": {{ json (.Header "X-GitHub-Event") }},
+    "source": {{ json (.Query "source") }}
+  }
+`), tr))
+
+	req := httptest.NewRequest(http.MethodPost, "/?source=github", strings.NewReader(`{"repository":{"full_name":"acme/\"app\""},"commits":[{"id":"abc"}]}`))
+	req.Header.Set("X-GitHub-Event", "push")
+	out, err := tr.Apply(req, "req-1")
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"repository":"acme/\"app\"","commit":"abc","event":"push","source":"github"}`, string(body))
+	assert.Equal(t, int64(len(body)), out.ContentLength)
+	assert.Equal(t, "application/json", out.Header.Get("Content-Type"))
+
+	invalid := &Transform{}
+	require.NoError(t, yaml.Unmarshal([]byte(`body: '{"event": {{ .Header "X-GitHub-Event" }}}'`), invalid))
+	_, err = invalid.Apply(req, "req-1")
+	assert.Error(t, err)
+}
+
+func TestTransform_ApplyKeepsBody(t *testing.T) {
+	tr := &Transform{}
+	require.NoError(t, yaml.Unmarshal([]byte(`headers:
+  set:
+    X-Action: '{{ .Field "$.action" }}'
+`), tr))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"action":"opened"}`))
+	out, err := tr.Apply(req, "req-1")
+	require.NoError(t, err)
+	assert.Equal(t, "opened", out.Header.Get("X-Action"))
+	body, _ := io.ReadAll(out.Body)
+	assert.Equal(t, `{"action":"opened"}`, string(body))
+}
@@ -1,8 +1,12 @@
 package model
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 )
 
@@ -19,6 +23,9 @@ const (
 	PropertyMethod Property = "method"
 	PropertyHeader Property = "header"
 	PropertyQuery  Property = "query"
+	// PropertyClientSubject is the subject of the client certificate verified
+	// by mutual TLS, e.g. "CN=billing,O=Example", and empty without one.
+	PropertyClientSubject Property = "client_subject"
 )
 
 func (p Property) Value(req *http.Request) (value interface{}) {
@@ -33,15 +40,58 @@ func (p Property) Value(req *http.Request) (value interface{}) {
 		return req.Header
 	case PropertyQuery:
 		return req.URL.Query()
+	case PropertyBody:
+		return parseBody(req)
+	case PropertyClientSubject:
+		return ClientSubject(req)
 	}
 	return nil
 }
 
+// ClientSubject returns the subject of the client certificate req was
+// verified with, or the one recorded with WithClientSubject for requests
+// rebuilt from the archive.
+func ClientSubject(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		subject, _ := req.Context().Value(ContextKey("client-subject")).(string)
+		return subject
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.String()
+}
+
+// WithClientSubject returns ctx carrying the client subject of a request
+// that is no longer on its TLS connection.
+func WithClientSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, ContextKey("client-subject"), subject)
+}
+
+// parseBody decodes the request body as JSON, leaving req.Body readable for
+// the forwarders.
+func parseBody(req *http.Request) JSONBody {
+	body := JSONBody{}
+	if req.Body == nil || req.Body == http.NoBody {
+		return body
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewBuffer(buf))
+	if err != nil {
+		return body
+	}
+
+	if err := json.Unmarshal(buf, &body.Document); err != nil {
+		body.Document = nil
+	}
+	return body
+}
+
 type Operator string
 
 const (
 	OperatorAnd Operator = "and"
 	OperatorOr  Operator = "or"
+	OperatorNot Operator = "not"
 )
 
 type PropertyValue struct {
@@ -62,6 +112,11 @@ func (t *Trigger) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal((*plain)(t)); err != nil {
 		return err
 	}
+	// Requests hold their headers under the canonical key, whichever case
+	// the rule is written in.
+	if t.Property == PropertyHeader {
+		t.Value.Key = http.CanonicalHeaderKey(t.Value.Key)
+	}
 	switch t.Comparator {
 	case Equal:
 		t.comparator = &ComparatorEqual{}
@@ -71,6 +126,20 @@ func (t *Trigger) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		t.comparator = &ComparatorContains{}
 	case NotContains:
 		t.comparator = &ComparatorNotContains{}
+	case Matches:
+		c, err := NewComparatorMatches(t.Value.Value)
+		if err != nil {
+			return err
+		}
+		t.comparator = c
+	case Glob:
+		c, err := NewComparatorGlob(t.Value.Value)
+		if err != nil {
+			return err
+		}
+		t.comparator = c
+	default:
+		return fmt.Errorf("%w %q", ErrUnsupportedComparator, t.Comparator)
 	}
 
 	return t.Validate()
@@ -78,7 +147,7 @@ func (t *Trigger) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 func (t *Trigger) Validate() error {
 	switch t.Property {
-	case PropertyPath, PropertyHost, PropertyMethod:
+	case PropertyPath, PropertyHost, PropertyMethod, PropertyClientSubject:
 		if t.Value.Value == "" {
 			return ErrEmptyRuleValue
 		}
@@ -86,9 +155,18 @@ func (t *Trigger) Validate() error {
 		if t.Value.Key == "" || t.Value.Value == "" {
 			return ErrEmptyRuleValue
 		}
-		if t.Comparator != Contains && t.Comparator != NotContains {
+		switch t.Comparator {
+		case Contains, NotContains, Matches, Glob:
+		default:
 			return fmt.Errorf("unsupported Comparator for property %s", t.Property)
 		}
+	case PropertyBody:
+		if t.Value.Key == "" || t.Value.Value == "" {
+			return ErrEmptyRuleValue
+		}
+		if _, err := parseJSONPath(t.Value.Key); err != nil {
+			return err
+		}
 	}
 	return nil
 }
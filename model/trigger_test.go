@@ -2,11 +2,15 @@ package model
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestValidate(t *testing.T) {
@@ -36,6 +40,13 @@ func TestValidate(t *testing.T) {
 			},
 			wantError: ErrEmptyRuleValue,
 		},
+		{
+			name: "PropertyClientSubject with empty value should error",
+			rule: &Trigger{
+				Property: PropertyClientSubject, Value: PropertyValue{Value: ""},
+			},
+			wantError: ErrEmptyRuleValue,
+		},
 		{
 			name: "PropertyHeader with empty key should error",
 			rule: &Trigger{
@@ -64,6 +75,27 @@ func TestValidate(t *testing.T) {
 				Comparator: "InvalidComparator"},
 			wantError: fmt.Errorf("unsupported Comparator for property %s", PropertyQuery),
 		},
+		{
+			name: "PropertyBody with empty path should error",
+			rule: &Trigger{
+				Property: PropertyBody, Value: PropertyValue{Key: "", Value: "push"},
+			},
+			wantError: ErrEmptyRuleValue,
+		},
+		{
+			name: "PropertyBody with invalid path should error",
+			rule: &Trigger{
+				Property: PropertyBody, Value: PropertyValue{Key: "$..type", Value: "push"},
+			},
+			wantError: fmt.Errorf("%w: %q", ErrInvalidJSONPath, "$..type"),
+		},
+		{
+			name: "PropertyBody with valid path should pass",
+			rule: &Trigger{
+				Property: PropertyBody, Value: PropertyValue{Key: "$.event.type", Value: "push"},
+				Comparator: Equal},
+			wantError: nil,
+		},
 		// You can add more test cases if needed
 	}
 
@@ -87,3 +119,60 @@ func TestRule_Match(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, result)
 }
+
+func TestRule_MatchBody(t *testing.T) {
+	rule := &Trigger{
+		Property:   PropertyBody,
+		Value:      PropertyValue{Key: "$.event.type", Value: "push"},
+		comparator: &ComparatorEqual{},
+	}
+
+	payload := `{"event":{"type":"push"}}`
+	req := &http.Request{URL: &url.URL{Path: "/"}, Body: io.NopCloser(strings.NewReader(payload))}
+	result, err := rule.Match(req)
+	assert.NoError(t, err)
+	assert.True(t, result)
+
+	// The body must still be readable by the forwarders.
+	buf, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, string(buf))
+
+	req = &http.Request{URL: &url.URL{Path: "/"}, Body: io.NopCloser(strings.NewReader("not json"))}
+	result, err = rule.Match(req)
+	assert.NoError(t, err)
+	assert.False(t, result)
+}
+
+func TestTrigger_MatchHeaderAnyCase(t *testing.T) {
+	req := &http.Request{URL: &url.URL{Path: "/"}, Header: http.Header{}}
+	req.Header.Set("X-GitHub-Event", "push")
+
+	for _, comparator := range []string{Contains, Matches, Glob} {
+		t.Run(comparator, func(t *testing.T) {
+			trigger := &Trigger{}
+			require.NoError(t, yaml.Unmarshal([]byte(fmt.Sprintf(`
+property: header
+comparator: %s
+value:
+  key: x-github-event
+  value: push`, comparator)), trigger))
+			assert.Equal(t, "X-Github-Event", trigger.Value.Key)
+
+			matched, err := trigger.Match(req)
+			require.NoError(t, err)
+			assert.True(t, matched)
+		})
+	}
+
+	trigger := &Trigger{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+property: header
+comparator: not_contains
+value:
+  key: x-github-event
+  value: push`), trigger))
+	matched, err := trigger.Match(req)
+	require.NoError(t, err)
+	assert.False(t, matched)
+}
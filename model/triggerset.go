@@ -2,54 +2,81 @@ package model
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnsupportedOperator = errors.New("unsupported operator")
 )
 
 type TriggerSet struct {
-	Triggers []Trigger `yaml:"triggers"`
-	Operator Operator  `yaml:"operator"`
+	Triggers    []Trigger    `yaml:"triggers"`
+	TriggerSets []TriggerSet `yaml:"triggersets"`
+	Operator    Operator     `yaml:"operator"`
+}
+
+func (ts *TriggerSet) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TriggerSet
+	if err := unmarshal((*plain)(ts)); err != nil {
+		return err
+	}
+	ts.Operator = Operator(strings.ToLower(string(ts.Operator)))
+	switch ts.Operator {
+	case "", OperatorAnd, OperatorOr, OperatorNot:
+		return nil
+	default:
+		return fmt.Errorf("%w %q", ErrUnsupportedOperator, ts.Operator)
+	}
 }
 
 // Match checks if the given request matches the rules defined in the Ruleset.
-// It uses the Operator to determine how rules should be combined (AND or OR logic).
+// Triggers are evaluated first, followed by the nested trigger sets, and
+// evaluation stops as soon as the outcome is known:
+//   - and (default): all members must match.
+//   - or: at least one member must match.
+//   - not: none of the members may match.
 func (ts *TriggerSet) Match(req *http.Request) (bool, error) {
 	var errs []error // Collects errors from rule matching
 
-	switch ts.Operator {
-	case OperatorOr:
-		// For OR logic, only one rule needs to match.
-		for _, r := range ts.Triggers {
-			result, err := r.Match(req)
-
-			// If there's an error, collect it.
-			if err != nil {
-				errs = append(errs, err)
-			}
-
-			// If a rule matches, return true immediately.
-			if result {
-				return true, errors.Join(errs...)
-			}
+	// decisive is the member result that settles the outcome on its own.
+	decisive := false
+	if ts.Operator == OperatorOr || ts.Operator == OperatorNot {
+		decisive = true
+	}
+
+	// outcome reports the set result given whether a decisive member was found.
+	outcome := func(found bool) bool {
+		if ts.Operator == OperatorOr {
+			return found
 		}
-		// If no rules matched, return false.
-		return false, errors.Join(errs...)
+		return !found
+	}
 
-	default:
-		// Default to AND logic: all rules must match.
-		for _, r := range ts.Triggers {
-			result, err := r.Match(req)
-
-			// If there's an error, collect it.
-			if err != nil {
-				errs = append(errs, err)
-			}
-
-			// If a rule doesn't match, return false immediately.
-			if !result {
-				return false, errors.Join(errs...)
-			}
+	for i := range ts.Triggers {
+		result, err := ts.Triggers[i].Match(req)
+
+		// If there's an error, collect it.
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if result == decisive {
+			return outcome(true), errors.Join(errs...)
 		}
-		// If all rules matched, return true.
-		return true, errors.Join(errs...)
 	}
+
+	for i := range ts.TriggerSets {
+		result, err := ts.TriggerSets[i].Match(req)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if result == decisive {
+			return outcome(true), errors.Join(errs...)
+		}
+	}
+
+	return outcome(false), errors.Join(errs...)
 }
@@ -1,8 +1,14 @@
 package model
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -56,6 +62,77 @@ func TestRuleset_Match(t *testing.T) {
 			wantErr:    false,
 			wantResult: true,
 		},
+		{
+			name: "NOT match",
+			TriggerSet: &TriggerSet{
+				Triggers: []Trigger{
+					{Property: PropertyPath, comparator: &ComparatorEqual{}, Value: PropertyValue{Value: "/other"}},
+					{Property: PropertyMethod, comparator: &ComparatorEqual{}, Value: PropertyValue{Value: "GET"}},
+				},
+				Operator: OperatorNot,
+			},
+			req:        &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/test"}},
+			wantErr:    false,
+			wantResult: true,
+		},
+		{
+			name: "NOT no match",
+			TriggerSet: &TriggerSet{
+				Triggers: []Trigger{
+					{Property: PropertyPath, comparator: &ComparatorEqual{}, Value: PropertyValue{Value: "/test"}},
+				},
+				Operator: OperatorNot,
+			},
+			req:        &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/test"}},
+			wantErr:    false,
+			wantResult: false,
+		},
+		{
+			name: "Nested match",
+			TriggerSet: &TriggerSet{
+				Triggers: []Trigger{
+					{Property: PropertyPath, comparator: &ComparatorEqual{}, Value: PropertyValue{Value: "/test"}},
+				},
+				TriggerSets: []TriggerSet{
+					{
+						Triggers: []Trigger{
+							{Property: PropertyHeader, comparator: &ComparatorContains{}, Value: PropertyValue{Key: "A", Value: "1"}},
+							{Property: PropertyHeader, comparator: &ComparatorContains{}, Value: PropertyValue{Key: "B", Value: "1"}},
+						},
+						Operator: OperatorOr,
+					},
+					{
+						Triggers: []Trigger{
+							{Property: PropertyQuery, comparator: &ComparatorContains{}, Value: PropertyValue{Key: "y", Value: "1"}},
+						},
+						Operator: OperatorNot,
+					},
+				},
+				Operator: OperatorAnd,
+			},
+			req:        &http.Request{Method: http.MethodPost, Header: http.Header{"B": []string{"1"}}, URL: &url.URL{Path: "/test", RawQuery: "y=2"}},
+			wantErr:    false,
+			wantResult: true,
+		},
+		{
+			name: "Nested no match",
+			TriggerSet: &TriggerSet{
+				Triggers: []Trigger{
+					{Property: PropertyPath, comparator: &ComparatorEqual{}, Value: PropertyValue{Value: "/test"}},
+				},
+				TriggerSets: []TriggerSet{
+					{
+						Triggers: []Trigger{
+							{Property: PropertyQuery, comparator: &ComparatorContains{}, Value: PropertyValue{Key: "y", Value: "1"}},
+						},
+						Operator: OperatorNot,
+					},
+				},
+			},
+			req:        &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/test", RawQuery: "y=1"}},
+			wantErr:    false,
+			wantResult: false,
+		},
 		{
 			name: "Header mismatch error",
 			TriggerSet: &TriggerSet{
@@ -142,6 +219,59 @@ operator: AND`),
 			wantErr:  false,
 			wantType: &ComparatorNotContains{},
 		},
+		{
+			name: "valid rule with matches comparator",
+			data: []byte(`triggers:
+  - property: path
+    comparator: matches
+    value:
+      value: ^/hooks/[^/]+/github$
+operator: and`),
+			wantErr:  false,
+			wantType: &ComparatorMatches{},
+		},
+		{
+			name: "valid rule with glob comparator",
+			data: []byte(`triggers:
+  - property: header
+    comparator: glob
+    value:
+      key: Content-Type
+      value: application/*
+operator: and`),
+			wantErr:  false,
+			wantType: &ComparatorGlob{},
+		},
+		{
+			name: "invalid regex pattern",
+			data: []byte(`triggers:
+  - property: path
+    comparator: matches
+    value:
+      value: "("
+operator: and`),
+			wantErr: true,
+		},
+		{
+			name: "unknown comparator",
+			data: []byte(`triggers:
+  - property: path
+    comparator: starts_with
+    value:
+      value: /hooks
+operator: and`),
+			wantErr: true,
+		},
+		{
+			name: "unknown operator",
+			data: []byte(`triggers:
+  - property: path
+    comparator: equal
+    value:
+      value: /hooks
+operator: xor`),
+			wantErr: true,
+		},
 		{
 			name:    "valid rule with not_equal comparator",
 			data:    []byte(`xxx---`),
@@ -163,6 +293,52 @@ operator: AND`),
 	}
 
 }
+
+func TestTriggerSet_UnmarshalYAMLNested(t *testing.T) {
+	data := []byte(`operator: AND
+triggers:
+  - property: path
+    comparator: equal
+    value:
+      value: /hooks/github
+triggersets:
+  - operator: or
+    triggers:
+      - property: header
+        comparator: contains
+        value:
+          key: X-Github-Event
+          value: push
+  - operator: NOT
+    triggers:
+      - property: query
+        comparator: contains
+        value:
+          key: dry_run
+          value: "1"
+`)
+	ts := &TriggerSet{}
+	assert.NoError(t, yaml.Unmarshal(data, ts))
+	assert.Equal(t, OperatorAnd, ts.Operator)
+	assert.Len(t, ts.TriggerSets, 2)
+	assert.Equal(t, OperatorOr, ts.TriggerSets[0].Operator)
+	assert.Equal(t, OperatorNot, ts.TriggerSets[1].Operator)
+
+	req := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"X-Github-Event": []string{"push"}},
+		URL:    &url.URL{Path: "/hooks/github"},
+	}
+	result, err := ts.Match(req)
+	assert.NoError(t, err)
+	assert.True(t, result)
+
+	req.URL.RawQuery = "dry_run=1"
+	result, err = ts.Match(req)
+	assert.NoError(t, err)
+	assert.False(t, result)
+}
+
 func TestProperty_Value(t *testing.T) {
 	tests := []struct {
 		property Property
@@ -206,6 +382,40 @@ func TestProperty_Value(t *testing.T) {
 				"key": []string{"value"},
 			},
 		},
+		{
+			property: PropertyBody,
+			req: &http.Request{
+				Body: io.NopCloser(strings.NewReader(`{"key":"value"}`)),
+			},
+			expected: JSONBody{Document: map[string]interface{}{"key": "value"}},
+		},
+		{
+			property: PropertyBody,
+			req:      &http.Request{},
+			expected: JSONBody{},
+		},
+		{
+			property: PropertyClientSubject,
+			req: &http.Request{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: "billing", Organization: []string{"Example"}}},
+			}}}},
+			expected: "CN=billing,O=Example",
+		},
+		{
+			property: PropertyClientSubject,
+			req:      &http.Request{TLS: &tls.ConnectionState{}},
+			expected: "",
+		},
+		{
+			property: PropertyClientSubject,
+			req:      &http.Request{},
+			expected: "",
+		},
+		{
+			property: PropertyClientSubject,
+			req:      (&http.Request{}).WithContext(WithClientSubject(context.Background(), "CN=billing,O=Example")),
+			expected: "CN=billing,O=Example",
+		},
 		{
 			property: Property("xxx"), // An invalid property to test the default return case
 			req:      &http.Request{},
@@ -0,0 +1,234 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	VerificationPresetGitHub = "github"
+	VerificationPresetStripe = "stripe"
+	VerificationPresetSlack  = "slack"
+)
+
+const (
+	AlgorithmSHA1   = "sha1"
+	AlgorithmSHA256 = "sha256"
+	AlgorithmSHA512 = "sha512"
+)
+
+const (
+	EncodingHex    = "hex"
+	EncodingBase64 = "base64"
+)
+
+// defaultTimestampTolerance applies to presets whose providers sign a
+// timestamp, matching what their own SDKs accept.
+const defaultTimestampTolerance = 300
+
+var (
+	ErrInvalidVerification = errors.New("invalid verification")
+	ErrSignatureMissing    = errors.New("signature missing")
+	ErrSignatureMismatch   = errors.New("signature mismatch")
+	ErrTimestampOutOfRange = errors.New("timestamp outside tolerance")
+)
+
+var timeNow = time.Now
+
+// Verification checks the HMAC signature a provider attaches to a webhook.
+// A preset fills in the provider's scheme; otherwise the signature in Header
+// is computed over the body, or over "<timestamp>.<body>" when
+// TimestampHeader is set. Tolerance is in seconds, zero disabling the check.
+type Verification struct {
+	Preset          string `yaml:"preset"`
+	Secret          string `yaml:"secret" json:"-"`
+	SecretEnv       string `yaml:"secret_env"`
+	Header          string `yaml:"header"`
+	Algorithm       string `yaml:"algorithm"`
+	Encoding        string `yaml:"encoding"`
+	Prefix          string `yaml:"prefix"`
+	TimestampHeader string `yaml:"timestamp_header"`
+	Tolerance       int    `yaml:"tolerance"`
+
+	secret []byte
+}
+
+func (v *Verification) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Verification
+	if err := unmarshal((*plain)(v)); err != nil {
+		return err
+	}
+	return v.Validate()
+}
+
+// Validate applies the preset defaults, resolves the secret and checks the
+// configuration is usable.
+func (v *Verification) Validate() error {
+	switch v.Preset {
+	case "":
+	case VerificationPresetGitHub:
+		v.Header = "X-Hub-Signature-256"
+		v.Algorithm, v.Encoding, v.Prefix = AlgorithmSHA256, EncodingHex, "sha256="
+	case VerificationPresetStripe:
+		v.Header = "Stripe-Signature"
+		v.Algorithm, v.Encoding = AlgorithmSHA256, EncodingHex
+	case VerificationPresetSlack:
+		v.Header, v.TimestampHeader = "X-Slack-Signature", "X-Slack-Request-Timestamp"
+		v.Algorithm, v.Encoding, v.Prefix = AlgorithmSHA256, EncodingHex, "v0="
+	default:
+		return fmt.Errorf("%w: unknown preset %q", ErrInvalidVerification, v.Preset)
+	}
+	if (v.Preset == VerificationPresetStripe || v.Preset == VerificationPresetSlack) && v.Tolerance == 0 {
+		v.Tolerance = defaultTimestampTolerance
+	}
+	if v.Algorithm == "" {
+		v.Algorithm = AlgorithmSHA256
+	}
+	if v.Encoding == "" {
+		v.Encoding = EncodingHex
+	}
+
+	if v.Header == "" {
+		return fmt.Errorf("%w: missing header", ErrInvalidVerification)
+	}
+	if _, err := v.hash(); err != nil {
+		return err
+	}
+	if v.Encoding != EncodingHex && v.Encoding != EncodingBase64 {
+		return fmt.Errorf("%w: unknown encoding %q", ErrInvalidVerification, v.Encoding)
+	}
+	if v.Tolerance < 0 {
+		return fmt.Errorf("%w: negative tolerance", ErrInvalidVerification)
+	}
+
+	secret := v.Secret
+	if v.SecretEnv != "" {
+		secret = os.Getenv(v.SecretEnv)
+	}
+	if secret == "" {
+		return fmt.Errorf("%w: missing secret", ErrInvalidVerification)
+	}
+	v.secret = []byte(secret)
+	return nil
+}
+
+// Verify checks the signature of req against its raw body.
+func (v *Verification) Verify(req *http.Request, body []byte) error {
+	header := req.Header.Get(v.Header)
+	if header == "" {
+		return ErrSignatureMissing
+	}
+
+	var (
+		timestamp  string
+		signatures []string
+	)
+	switch v.Preset {
+	case VerificationPresetStripe:
+		// t=<timestamp>,v1=<signature>[,v1=<signature>...]
+		for _, part := range strings.Split(header, ",") {
+			k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "t":
+				timestamp = val
+			case "v1":
+				signatures = append(signatures, val)
+			}
+		}
+		if timestamp == "" {
+			return ErrSignatureMissing
+		}
+	default:
+		if v.TimestampHeader != "" {
+			timestamp = req.Header.Get(v.TimestampHeader)
+			if timestamp == "" {
+				return ErrSignatureMissing
+			}
+		}
+		signatures = []string{strings.TrimPrefix(header, v.Prefix)}
+	}
+
+	if err := v.checkTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	expected, err := v.sign(v.signedPayload(timestamp, body))
+	if err != nil {
+		return err
+	}
+	for _, s := range signatures {
+		actual, err := v.decode(s)
+		if err == nil && hmac.Equal(actual, expected) {
+			return nil
+		}
+	}
+	return ErrSignatureMismatch
+}
+
+func (v *Verification) signedPayload(timestamp string, body []byte) []byte {
+	switch {
+	case v.Preset == VerificationPresetSlack:
+		return append([]byte("v0:"+timestamp+":"), body...)
+	case timestamp != "":
+		return append([]byte(timestamp+"."), body...)
+	default:
+		return body
+	}
+}
+
+func (v *Verification) checkTimestamp(timestamp string) error {
+	if timestamp == "" || v.Tolerance == 0 {
+		return nil
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrTimestampOutOfRange, timestamp)
+	}
+	if math.Abs(float64(timeNow().Unix()-ts)) > float64(v.Tolerance) {
+		return ErrTimestampOutOfRange
+	}
+	return nil
+}
+
+func (v *Verification) sign(payload []byte) ([]byte, error) {
+	h, err := v.hash()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(h, v.secret)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+func (v *Verification) decode(signature string) ([]byte, error) {
+	if v.Encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(signature)
+	}
+	return hex.DecodeString(signature)
+}
+
+func (v *Verification) hash() (func() hash.Hash, error) {
+	switch v.Algorithm {
+	case AlgorithmSHA1:
+		return sha1.New, nil
+	case AlgorithmSHA256:
+		return sha256.New, nil
+	case AlgorithmSHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidVerification, v.Algorithm)
+	}
+}
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func hmacSHA256(secret, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func newVerification(t *testing.T, doc string) *Verification {
+	v := &Verification{}
+	require.NoError(t, yaml.Unmarshal([]byte(doc), v))
+	return v
+}
+
+func signedRequest(body string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+func TestVerification_UnmarshalYAML(t *testing.T) {
+	v := newVerification(t, "preset: github\nsecret: s3cr3t")
+	assert.Equal(t, "X-Hub-Signature-256", v.Header)
+	assert.Equal(t, AlgorithmSHA256, v.Algorithm)
+
+	t.Setenv("HOOKIE_TEST_SECRET", "from-env")
+	v = newVerification(t, "header: X-Signature\nsecret_env: HOOKIE_TEST_SECRET")
+	assert.Equal(t, []byte("from-env"), v.secret)
+	assert.Equal(t, EncodingHex, v.Encoding)
+
+	for _, doc := range []string{
+		"preset: gitlab\nsecret: s",
+		"secret: s",
+		"header: X-Signature",
+		"header: X-Signature\nsecret: s\nalgorithm: md5",
+		"header: X-Signature\nsecret: s\nencoding: base32",
+		"header: X-Signature\nsecret: s\ntolerance: -1",
+	} {
+		err := yaml.Unmarshal([]byte(doc), &Verification{})
+		assert.ErrorIs(t, err, ErrInvalidVerification, doc)
+	}
+}
+
+func TestVerification_Verify(t *testing.T) {
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	ts := "1700000000"
+	body := `{"id":1}`
+
+	github := newVerification(t, "preset: github\nsecret: s3cr3t")
+	sig := "sha256=" + hex.EncodeToString(hmacSHA256("s3cr3t", body))
+	assert.NoError(t, github.Verify(signedRequest(body, map[string]string{"X-Hub-Signature-256": sig}), []byte(body)))
+	assert.ErrorIs(t, github.Verify(signedRequest(body, map[string]string{"X-Hub-Signature-256": sig}), []byte(`{"id":2}`)), ErrSignatureMismatch)
+	assert.ErrorIs(t, github.Verify(signedRequest(body, nil), []byte(body)), ErrSignatureMissing)
+
+	stripe := newVerification(t, "preset: stripe\nsecret: whsec")
+	sig = hex.EncodeToString(hmacSHA256("whsec", ts+"."+body))
+	header := "t=" + ts + ",v1=deadbeef,v1=" + sig
+	assert.NoError(t, stripe.Verify(signedRequest(body, map[string]string{"Stripe-Signature": header}), []byte(body)))
+	timeNow = func() time.Time { return now.Add(10 * time.Minute) }
+	assert.ErrorIs(t, stripe.Verify(signedRequest(body, map[string]string{"Stripe-Signature": header}), []byte(body)), ErrTimestampOutOfRange)
+	timeNow = func() time.Time { return now }
+
+	slack := newVerification(t, "preset: slack\nsecret: xoxs")
+	sig = "v0=" + hex.EncodeToString(hmacSHA256("xoxs", "v0:"+ts+":"+body))
+	assert.NoError(t, slack.Verify(signedRequest(body, map[string]string{
+		"X-Slack-Signature":         sig,
+		"X-Slack-Request-Timestamp": ts,
+	}), []byte(body)))
+	assert.ErrorIs(t, slack.Verify(signedRequest(body, map[string]string{"X-Slack-Signature": sig}), []byte(body)), ErrSignatureMissing)
+
+	generic := newVerification(t, "header: X-Signature\nsecret: k\nalgorithm: sha1\nencoding: base64")
+	mac := hmac.New(sha1.New, []byte("k"))
+	mac.Write([]byte(body))
+	sig = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	assert.NoError(t, generic.Verify(signedRequest(body, map[string]string{"X-Signature": sig}), []byte(body)))
+	assert.ErrorIs(t, generic.Verify(signedRequest(body, map[string]string{"X-Signature": "not base64"}), []byte(body)), ErrSignatureMismatch)
+}
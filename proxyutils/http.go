@@ -3,16 +3,31 @@ package proxyutils
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+
+	"github.com/thebluefowl/hookie/model"
 )
 
 type TargetRequest struct {
 	ID      string
 	Request *http.Request
+
+	// Delivery state carried through the queue.
+	Rule      string
+	Attempts  int
+	Retry     model.RetryPolicy
+	Timeout   time.Duration
+	NotBefore time.Time
+	Signing   *model.Signing
+	RateLimit *model.RateLimit
+	// Tracked deliveries update the delivery record of ID.
+	Tracked bool
 }
 
 func NewTargetRequest(id string, in *http.Request, target *url.URL) (*TargetRequest, error) {
@@ -38,12 +53,95 @@ func NewTargetRequest(id string, in *http.Request, target *url.URL) (*TargetRequ
 }
 
 type SerializableRequest struct {
-	ID      string
-	Headers map[string][]string
-	Body    []byte
-	Method  string
-	URL     string
-	Host    string
+	ID        string
+	Headers   map[string][]string
+	Body      []byte
+	Method    string
+	URL       string
+	Host      string
+	Rule      string
+	Attempts  int
+	Retry     model.RetryPolicy
+	Timeout   time.Duration
+	NotBefore time.Time
+	RateLimit *model.RateLimit `json:",omitempty"`
+	Tracked   bool             `json:",omitempty"`
+	// Signing names the environment variable consumers resolve the signing
+	// secret from, so that the secret is not written to the queue.
+	Signing *model.Signing `json:",omitempty"`
+}
+
+// NewSerializableRequest captures req as received, e.g. for archiving. The
+// body is buffered and req.Body rewound so the request can still be served.
+func NewSerializableRequest(id string, req *http.Request) (*SerializableRequest, error) {
+	payload := &SerializableRequest{
+		ID:      id,
+		Headers: make(map[string][]string),
+		Body:    make([]byte, 0),
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Host:    req.Host,
+	}
+	for k, v := range req.Header {
+		payload.Headers[k] = v
+	}
+	if req.Body != nil {
+		buf, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Body.Close(); err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewBuffer(buf))
+		payload.Body = buf
+	}
+	return payload, nil
+}
+
+// Request rebuilds the HTTP request described by the payload.
+func (p *SerializableRequest) Request() *http.Request {
+	req := &http.Request{
+		Method:     p.Method,
+		URL:        &url.URL{},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       p.Host,
+	}
+	for k, v := range p.Headers {
+		req.Header[k] = v
+	}
+	req.URL, _ = url.Parse(p.URL)
+	body := p.Body
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewBuffer(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	return req
+}
+
+// Sign sets the signature headers on the request when signing is configured.
+// The body is read through GetBody so that the request can still be sent.
+func (tr *TargetRequest) Sign() error {
+	if tr.Signing == nil {
+		return nil
+	}
+	var body []byte
+	if tr.Request.GetBody != nil {
+		rc, err := tr.Request.GetBody()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if body, err = io.ReadAll(rc); err != nil {
+			return err
+		}
+	}
+	tr.Signing.Sign(tr.Request, tr.ID, body)
+	return nil
 }
 
 func (tr *TargetRequest) MarshalJSON() ([]byte, error) {
@@ -54,6 +152,20 @@ func (tr *TargetRequest) MarshalJSON() ([]byte, error) {
 		Host:    tr.Request.Host,
 		Method:  tr.Request.Method,
 		URL:     tr.Request.URL.String(),
+
+		Rule:      tr.Rule,
+		Attempts:  tr.Attempts,
+		Retry:     tr.Retry,
+		Timeout:   tr.Timeout,
+		NotBefore: tr.NotBefore,
+		RateLimit: tr.RateLimit,
+		Tracked:   tr.Tracked,
+	}
+	if tr.Signing != nil {
+		if tr.Signing.SecretEnv == "" {
+			return nil, model.ErrInlineSecret
+		}
+		payload.Signing = &model.Signing{SecretEnv: tr.Signing.SecretEnv}
 	}
 	for k, v := range tr.Request.Header {
 		payload.Headers[k] = v
@@ -75,18 +187,21 @@ func (tr *TargetRequest) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, payload); err != nil {
 		return err
 	}
-	tr.Request = &http.Request{
-		Method: payload.Method,
-		URL:    &url.URL{},
-		Header: make(http.Header),
-	}
-	for k, v := range payload.Headers {
-		tr.Request.Header[k] = v
-	}
+	tr.Request = payload.Request()
 	tr.ID = payload.ID
-	tr.Request.URL, _ = url.Parse(payload.URL)
-	tr.Request.Body = io.NopCloser(bytes.NewBuffer(payload.Body))
-	tr.Request.Host = payload.Host
+	tr.Rule = payload.Rule
+	tr.Attempts = payload.Attempts
+	tr.Retry = payload.Retry
+	tr.Timeout = payload.Timeout
+	tr.NotBefore = payload.NotBefore
+	tr.RateLimit = payload.RateLimit
+	tr.Tracked = payload.Tracked
+	if payload.Signing != nil {
+		if err := payload.Signing.Validate(); err != nil {
+			return fmt.Errorf("failed to resolve signing secret: %w", err)
+		}
+		tr.Signing = payload.Signing
+	}
 	return nil
 }
 
@@ -159,6 +274,9 @@ func copyBody(in, out *http.Request) error {
 
 	in.Body = io.NopCloser(bytes.NewBuffer(buf))
 	out.Body = io.NopCloser(bytes.NewBuffer(buf))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewBuffer(buf)), nil
+	}
 
 	return nil
 }
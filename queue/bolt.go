@@ -0,0 +1,311 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thebluefowl/hookie/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMessagesBucket    = []byte("messages")
+	boltDelayedBucket     = []byte("delayed")
+	boltDeadLettersBucket = []byte("dead-letters")
+)
+
+// boltPollInterval bounds how long a consumer sleeps when it missed a publish
+// notification, e.g. for messages left over from a previous run.
+const boltPollInterval = 5 * time.Second
+
+// Bolt is a durable queue embedded in a single bbolt file, for single-node
+// deployments without a broker. A message is only removed once it has been
+// processed, so deliveries interrupted by a crash are retried on restart.
+type Bolt struct {
+	db          *bolt.DB
+	messages    *boltList
+	deadLetters *boltList
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt queue: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMessagesBucket, boltDelayedBucket, boltDeadLettersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt queue buckets: %w", err)
+	}
+
+	return &Bolt{
+		db:          db,
+		messages:    newBoltList(db, boltMessagesBucket, boltDelayedBucket),
+		deadLetters: newBoltList(db, boltDeadLettersBucket, nil),
+	}, nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// Ping fails once the database has been closed.
+func (b *Bolt) Ping(ctx context.Context) error {
+	return b.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (b *Bolt) Publish(ctx context.Context, payload []byte) error {
+	return b.messages.push(payload)
+}
+
+// PublishDelayed stores payload aside until delay has passed, after which the
+// consumer moves it to the queue.
+func (b *Bolt) PublishDelayed(ctx context.Context, payload []byte, delay time.Duration) error {
+	return b.messages.pushDelayed(payload, time.Now().Add(delay))
+}
+
+func (b *Bolt) StartConsumer(ctx context.Context, processor func(payload interface{}) error) error {
+	return b.messages.consume(ctx, func(payload []byte) error {
+		return processor(payload)
+	})
+}
+
+func (b *Bolt) PublishDeadLetter(ctx context.Context, dl *model.DeadLetter) error {
+	body, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return b.deadLetters.push(body)
+}
+
+func (b *Bolt) StartDeadLetterConsumer(ctx context.Context, processor func(dl *model.DeadLetter) error) error {
+	return b.deadLetters.consume(ctx, func(payload []byte) error {
+		dl := &model.DeadLetter{}
+		if err := json.Unmarshal(payload, dl); err != nil {
+			return NewError(err, true)
+		}
+		return processor(dl)
+	})
+}
+
+// boltList is a FIFO stored in one bucket, keyed by the bucket sequence.
+// Delayed messages, if enabled, wait in a second bucket keyed by the time they
+// are due.
+type boltList struct {
+	db      *bolt.DB
+	bucket  []byte
+	delayed []byte
+	notify  chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[uint64]bool
+}
+
+func newBoltList(db *bolt.DB, bucket, delayed []byte) *boltList {
+	return &boltList{
+		db:       db,
+		bucket:   bucket,
+		delayed:  delayed,
+		notify:   make(chan struct{}, 1),
+		inFlight: make(map[uint64]bool),
+	}
+}
+
+func (l *boltList) push(payload []byte) error {
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(l.bucket), payload)
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (l *boltList) pushDelayed(payload []byte, due time.Time) error {
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(l.delayed)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(append(itob(uint64(due.UnixNano())), itob(seq)...), payload)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Wake the consumer so that it sleeps no longer than until due.
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// promote moves the delayed messages that are due to the tail of the list,
+// and returns when the next one is due, zero if there is none.
+func (l *boltList) promote(t time.Time) (time.Time, error) {
+	if l.delayed == nil {
+		return time.Time{}, nil
+	}
+
+	var next time.Time
+	due := func(k []byte) bool {
+		next = time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+		return !next.After(t)
+	}
+	var pending bool
+	err := l.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(l.delayed).Cursor().First(); k != nil {
+			pending = due(k)
+		}
+		return nil
+	})
+	if err != nil || !pending {
+		return next, err
+	}
+
+	next = time.Time{}
+	err = l.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(l.delayed).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			if !due(k) {
+				return nil
+			}
+			if err := put(tx.Bucket(l.bucket), v); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		next = time.Time{}
+		return nil
+	})
+	return next, err
+}
+
+// claim returns the oldest message not already being processed.
+func (l *boltList) claim() (uint64, []byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		key     uint64
+		payload []byte
+		found   bool
+	)
+	err := l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(l.bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			id := binary.BigEndian.Uint64(k)
+			if l.inFlight[id] {
+				continue
+			}
+			key, payload, found = id, append([]byte(nil), v...), true
+			return nil
+		}
+		return nil
+	})
+	if err != nil || !found {
+		return 0, nil, false, err
+	}
+	l.inFlight[key] = true
+	return key, payload, true, nil
+}
+
+// release removes the message, re-appending it to the tail when requeue is set.
+func (l *boltList) release(key uint64, payload []byte, requeue bool) error {
+	defer func() {
+		l.mu.Lock()
+		delete(l.inFlight, key)
+		l.mu.Unlock()
+	}()
+
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(l.bucket)
+		if err := b.Delete(itob(key)); err != nil {
+			return err
+		}
+		if requeue {
+			return put(b, payload)
+		}
+		return nil
+	})
+}
+
+func (l *boltList) consume(ctx context.Context, processor func([]byte) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		next, err := l.promote(time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to promote delayed messages: %w", err)
+		}
+		key, payload, ok, err := l.claim()
+		if err != nil {
+			return fmt.Errorf("failed to read bolt queue: %w", err)
+		}
+		if !ok {
+			idle := boltPollInterval
+			if !next.IsZero() && time.Until(next) < idle {
+				idle = time.Until(next)
+			}
+			select {
+			case <-l.notify:
+			case <-time.After(idle):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		perr := processor(payload)
+		requeue := perr != nil && !isFatal(perr)
+		if err := l.release(key, payload, requeue); err != nil {
+			return fmt.Errorf("failed to update bolt queue: %w", err)
+		}
+		if requeue {
+			select {
+			case <-time.After(requeueBackoff):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+func put(b *bolt.Bucket, payload []byte) error {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(seq), payload)
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
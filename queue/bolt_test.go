@@ -0,0 +1,126 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/model"
+)
+
+func TestBolt_StartConsumer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	b, err := NewBolt(path)
+	require.NoError(t, err)
+
+	for _, msg := range []string{"1", "2"} {
+		require.NoError(t, b.Publish(context.TODO(), []byte(msg)))
+	}
+
+	// Messages survive a restart.
+	require.NoError(t, b.Close())
+	b, err = NewBolt(path)
+	require.NoError(t, err)
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	received := make(chan string, 10)
+	failed := false
+	done := make(chan error)
+	go func() {
+		done <- b.StartConsumer(ctx, func(body interface{}) error {
+			msg := string(body.([]byte))
+			if msg == "1" && !failed {
+				failed = true
+				return NewError(errors.New("retry"), false)
+			}
+			received <- msg
+			return nil
+		})
+	}()
+
+	// "1" is requeued behind "2" after its first failure.
+	for _, want := range []string{"2", "1"} {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("did not receive %s", want)
+		}
+	}
+
+	require.NoError(t, b.Publish(context.TODO(), []byte("3")))
+	select {
+	case got := <-received:
+		assert.Equal(t, "3", got)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive message published while consuming")
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestBolt_DeadLetter(t *testing.T) {
+	b, err := NewBolt(filepath.Join(t.TempDir(), "queue.db"))
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.NoError(t, b.PublishDeadLetter(context.TODO(), &model.DeadLetter{RequestID: "1", LastStatus: 503}))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	received := make(chan *model.DeadLetter, 1)
+	go b.StartDeadLetterConsumer(ctx, func(dl *model.DeadLetter) error {
+		received <- dl
+		return nil
+	})
+
+	select {
+	case dl := <-received:
+		assert.Equal(t, "1", dl.RequestID)
+		assert.Equal(t, 503, dl.LastStatus)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive dead letter")
+	}
+}
+
+func TestBolt_PublishDelayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	b, err := NewBolt(path)
+	require.NoError(t, err)
+	published := time.Now()
+	require.NoError(t, b.PublishDelayed(context.TODO(), []byte("later"), 300*time.Millisecond))
+	require.NoError(t, b.Publish(context.TODO(), []byte("now")))
+
+	// Delayed messages survive a restart.
+	require.NoError(t, b.Close())
+	b, err = NewBolt(path)
+	require.NoError(t, err)
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	received := make(chan string, 10)
+	go b.StartConsumer(ctx, func(body interface{}) error {
+		received <- string(body.([]byte))
+		return nil
+	})
+
+	for _, want := range []string{"now", "later"} {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("did not receive %s", want)
+		}
+	}
+	assert.GreaterOrEqual(t, time.Since(published), 300*time.Millisecond)
+}
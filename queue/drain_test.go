@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wagslane/go-rabbitmq"
+)
+
+func TestDrain(t *testing.T) {
+	var d drain
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := d.wrap(func(rabbitmq.Delivery) rabbitmq.Action {
+		close(started)
+		<-release
+		return rabbitmq.Ack
+	})
+
+	acked := make(chan rabbitmq.Action, 1)
+	go func() { acked <- handler(rabbitmq.Delivery{}) }()
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		d.wait()
+		close(drained)
+	}()
+
+	// Messages arriving once the drain started are requeued untouched.
+	assert.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.stopping
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, rabbitmq.NackRequeue, handler(rabbitmq.Delivery{}))
+
+	select {
+	case <-drained:
+		t.Fatal("drain did not wait for the message in progress")
+	default:
+	}
+	close(release)
+	assert.Equal(t, rabbitmq.Ack, <-acked)
+	<-drained
+}
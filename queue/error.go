@@ -1,5 +1,14 @@
 package queue
 
+import (
+	"errors"
+	"time"
+)
+
+// requeueBackoff is how long the embedded queues pause after a retryable
+// processing error, so a failing message at the head does not spin.
+const requeueBackoff = time.Second
+
 type Error struct {
 	Err   error
 	Fatal bool
@@ -19,3 +28,10 @@ func NewError(err error, isFatal bool) *Error {
 		Fatal: isFatal,
 	}
 }
+
+// isFatal reports whether err says the message should be dropped rather than
+// redelivered. Errors that are not an *Error are treated as retryable.
+func isFatal(err error) bool {
+	e := &Error{}
+	return errors.As(err, &e) && e.IsFatal()
+}
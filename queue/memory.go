@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/thebluefowl/hookie/model"
+)
+
+// Memory is an in-process queue for development and tests. Messages are lost
+// when the process exits.
+type Memory struct {
+	messages    *memoryList
+	deadLetters *memoryList
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		messages:    newMemoryList(),
+		deadLetters: newMemoryList(),
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, payload []byte) error {
+	m.messages.push(payload)
+	return nil
+}
+
+// PublishDelayed holds payload in memory until delay has passed.
+func (m *Memory) PublishDelayed(ctx context.Context, payload []byte, delay time.Duration) error {
+	time.AfterFunc(delay, func() { m.messages.push(payload) })
+	return nil
+}
+
+func (m *Memory) StartConsumer(ctx context.Context, processor func(payload interface{}) error) error {
+	return m.messages.consume(ctx, func(payload []byte) error {
+		return processor(payload)
+	})
+}
+
+func (m *Memory) PublishDeadLetter(ctx context.Context, dl *model.DeadLetter) error {
+	body, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	m.deadLetters.push(body)
+	return nil
+}
+
+func (m *Memory) StartDeadLetterConsumer(ctx context.Context, processor func(dl *model.DeadLetter) error) error {
+	return m.deadLetters.consume(ctx, func(payload []byte) error {
+		dl := &model.DeadLetter{}
+		if err := json.Unmarshal(payload, dl); err != nil {
+			return NewError(err, true)
+		}
+		return processor(dl)
+	})
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Len returns the number of messages waiting to be consumed.
+func (m *Memory) Len() int {
+	return m.messages.len()
+}
+
+// memoryList is an unbounded FIFO whose consumers block until a message
+// arrives.
+type memoryList struct {
+	mu     sync.Mutex
+	items  [][]byte
+	notify chan struct{}
+}
+
+func newMemoryList() *memoryList {
+	return &memoryList{notify: make(chan struct{}, 1)}
+}
+
+func (l *memoryList) push(payload []byte) {
+	l.mu.Lock()
+	l.items = append(l.items, payload)
+	l.mu.Unlock()
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (l *memoryList) pop() ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.items) == 0 {
+		return nil, false
+	}
+	payload := l.items[0]
+	l.items = l.items[1:]
+	return payload, true
+}
+
+func (l *memoryList) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.items)
+}
+
+func (l *memoryList) consume(ctx context.Context, processor func([]byte) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		payload, ok := l.pop()
+		if !ok {
+			select {
+			case <-l.notify:
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		if err := processor(payload); err != nil && !isFatal(err) {
+			l.push(payload)
+			select {
+			case <-time.After(requeueBackoff):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
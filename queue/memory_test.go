@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/model"
+)
+
+func TestMemory_StartConsumer(t *testing.T) {
+	m := NewMemory()
+
+	messages := []string{"1", "2", "3"}
+	for _, msg := range messages {
+		require.NoError(t, m.Publish(context.TODO(), []byte(msg)))
+	}
+	assert.Equal(t, len(messages), m.Len())
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	received := make(chan string, 10)
+	failed := false
+	done := make(chan error)
+	go func() {
+		done <- m.StartConsumer(ctx, func(body interface{}) error {
+			msg := string(body.([]byte))
+			// Fail "2" once with a retryable error and "3" fatally.
+			if msg == "2" && !failed {
+				failed = true
+				return NewError(errors.New("retry"), false)
+			}
+			if msg == "3" {
+				return NewError(errors.New("fatal"), true)
+			}
+			received <- msg
+			return nil
+		})
+	}()
+
+	for _, want := range []string{"1", "2"} {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("did not receive %s", want)
+		}
+	}
+	assert.Equal(t, 0, m.Len())
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestMemory_PublishDelayed(t *testing.T) {
+	m := NewMemory()
+	require.NoError(t, m.PublishDelayed(context.TODO(), []byte("later"), 100*time.Millisecond))
+	assert.Equal(t, 0, m.Len())
+
+	require.Eventually(t, func() bool { return m.Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestMemory_DeadLetter(t *testing.T) {
+	m := NewMemory()
+	require.NoError(t, m.PublishDeadLetter(context.TODO(), &model.DeadLetter{RequestID: "1", Attempts: 3}))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	received := make(chan *model.DeadLetter, 1)
+	go m.StartDeadLetterConsumer(ctx, func(dl *model.DeadLetter) error {
+		received <- dl
+		return nil
+	})
+
+	select {
+	case dl := <-received:
+		assert.Equal(t, "1", dl.RequestID)
+		assert.Equal(t, 3, dl.Attempts)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive dead letter")
+	}
+}
@@ -2,31 +2,74 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/thebluefowl/hookie/model"
 	"github.com/wagslane/go-rabbitmq"
+	"golang.org/x/exp/slog"
 )
 
 const RMQDefaultExchangeName = "hookie.exchange.default"
 const RMQDefaultRoutingKey = "hookie.webhook.default"
 const RMQDefaultQueueName = "hookie.webhook.default"
 
+const RMQDefaultDeadLetterExchangeName = "hookie.exchange.dead-letter"
+const RMQDefaultDeadLetterRoutingKey = "hookie.webhook.dead-letter"
+const RMQDefaultDeadLetterQueueName = "hookie.webhook.dead-letter"
+
+// rmqRedialInterval is how long the monitor connection waits between attempts
+// to reconnect to the broker, and rmqDialTimeout how long each may take.
+const (
+	rmqRedialInterval = 5 * time.Second
+	rmqDialTimeout    = 5 * time.Second
+)
+
+// rmqDelays are the TTLs of the queues in which delayed messages wait before
+// being dead-lettered back to the exchange. A queue expires messages in order
+// only when they share a TTL, so delays are rounded down to one of these and
+// a message that comes back early is delayed again for the rest.
+var rmqDelays = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+}
+
 type RabbitMQ struct {
-	conn         *rabbitmq.Conn
-	publisher    *rabbitmq.Publisher
-	ExchangeName string
-	RoutingKey   string
-	QueueName    string
+	conn                   *rabbitmq.Conn
+	publisher              *rabbitmq.Publisher
+	url                    string
+	mu                     sync.Mutex
+	monitor                *amqp.Connection
+	monitorErr             error
+	delayQueues            map[time.Duration]string
+	done                   chan struct{}
+	ExchangeName           string
+	RoutingKey             string
+	QueueName              string
+	DeadLetterExchangeName string
+	DeadLetterRoutingKey   string
+	DeadLetterQueueName    string
 }
 
 type RabbitMQOpts struct {
-	Username     string
-	Password     string
-	Host         string
-	Port         int
-	ExchangeName string
-	RoutingKey   string
-	QueueName    string
+	Username               string
+	Password               string
+	Host                   string
+	Port                   int
+	ExchangeName           string
+	RoutingKey             string
+	QueueName              string
+	DeadLetterExchangeName string
+	DeadLetterRoutingKey   string
+	DeadLetterQueueName    string
 }
 
 func NewRabbitMQ(opts *RabbitMQOpts) (*RabbitMQ, error) {
@@ -39,13 +82,33 @@ func NewRabbitMQ(opts *RabbitMQOpts) (*RabbitMQ, error) {
 	if opts.QueueName == "" {
 		opts.QueueName = RMQDefaultQueueName
 	}
-	conn, err := rabbitmq.NewConn(
-		fmt.Sprintf("amqp://%s:%s@%s:%d/", opts.Username, opts.Password, opts.Host, opts.Port),
-	)
+	if opts.DeadLetterExchangeName == "" {
+		opts.DeadLetterExchangeName = RMQDefaultDeadLetterExchangeName
+	}
+	if opts.DeadLetterRoutingKey == "" {
+		opts.DeadLetterRoutingKey = RMQDefaultDeadLetterRoutingKey
+	}
+	if opts.DeadLetterQueueName == "" {
+		opts.DeadLetterQueueName = RMQDefaultDeadLetterQueueName
+	}
+	url := fmt.Sprintf("amqp://%s:%s@%s:%d/", opts.Username, opts.Password, opts.Host, opts.Port)
+	conn, err := rabbitmq.NewConn(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
+	monitor, err := amqp.Dial(url)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	if err := declareDeadLetterQueue(monitor, opts); err != nil {
+		monitor.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare RabbitMQ dead-letter queue: %w", err)
+	}
+
 	publisher, err := rabbitmq.NewPublisher(
 		conn,
 		rabbitmq.WithPublisherOptionsExchangeName(opts.ExchangeName),
@@ -54,16 +117,121 @@ func NewRabbitMQ(opts *RabbitMQOpts) (*RabbitMQ, error) {
 	)
 
 	if err != nil {
+		monitor.Close()
+		conn.Close()
 		return nil, fmt.Errorf("failed to create RabbitMQ publisher: %w", err)
 	}
 
-	return &RabbitMQ{
-		conn:         conn,
-		publisher:    publisher,
-		ExchangeName: opts.ExchangeName,
-		RoutingKey:   opts.RoutingKey,
-		QueueName:    opts.QueueName,
-	}, nil
+	r := &RabbitMQ{
+		conn:                   conn,
+		publisher:              publisher,
+		url:                    url,
+		monitor:                monitor,
+		delayQueues:            make(map[time.Duration]string),
+		done:                   make(chan struct{}),
+		ExchangeName:           opts.ExchangeName,
+		RoutingKey:             opts.RoutingKey,
+		QueueName:              opts.QueueName,
+		DeadLetterExchangeName: opts.DeadLetterExchangeName,
+		DeadLetterRoutingKey:   opts.DeadLetterRoutingKey,
+		DeadLetterQueueName:    opts.DeadLetterQueueName,
+	}
+	go r.watch(monitor)
+	return r, nil
+}
+
+// declareDeadLetterQueue sets up the durable dead-letter exchange and queue
+// up front, so that dead letters are retained even before anything consumes
+// them.
+func declareDeadLetterQueue(conn *amqp.Connection, opts *RabbitMQOpts) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.ExchangeDeclare(opts.DeadLetterExchangeName, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(opts.DeadLetterQueueName, true, false, false, false, nil); err != nil {
+		return err
+	}
+	return ch.QueueBind(opts.DeadLetterQueueName, opts.DeadLetterRoutingKey, opts.DeadLetterExchangeName, false, nil)
+}
+
+// Ping reports whether the broker is reachable. go-rabbitmq reconnects on its
+// own without exposing its state, so a separate connection is watched instead.
+func (r *RabbitMQ) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.monitorErr != nil {
+		return fmt.Errorf("lost connection to RabbitMQ: %w", r.monitorErr)
+	}
+	return nil
+}
+
+// watch records when monitor is closed by the broker or the network, and
+// then re-dials in the background until connected again or closed.
+func (r *RabbitMQ) watch(monitor *amqp.Connection) {
+	for {
+		select {
+		case err := <-monitor.NotifyClose(make(chan *amqp.Error, 1)):
+			var lost error = amqp.ErrClosed
+			if err != nil {
+				lost = err
+			}
+			if !r.setMonitor(nil, lost) {
+				return
+			}
+		case <-r.done:
+			return
+		}
+
+		var ok bool
+		if monitor, ok = r.redial(); !ok {
+			return
+		}
+	}
+}
+
+// redial connects the monitor again, returning false once closed.
+func (r *RabbitMQ) redial() (*amqp.Connection, bool) {
+	for {
+		select {
+		case <-time.After(rmqRedialInterval):
+		case <-r.done:
+			return nil, false
+		}
+		monitor, err := amqp.DialConfig(r.url, amqp.Config{Dial: amqp.DefaultDial(rmqDialTimeout)})
+		if err != nil {
+			if !r.setMonitor(nil, err) {
+				return nil, false
+			}
+			continue
+		}
+		if !r.setMonitor(monitor, nil) {
+			monitor.Close()
+			return nil, false
+		}
+		return monitor, true
+	}
+}
+
+// setMonitor records the monitor connection and why there is none, and
+// reports whether it did, which it does not once closed.
+func (r *RabbitMQ) setMonitor(monitor *amqp.Connection, err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.done:
+		return false
+	default:
+	}
+	r.monitor, r.monitorErr = monitor, err
+	return true
 }
 
 func (r *RabbitMQ) Publish(ctx context.Context, body []byte) error {
@@ -76,17 +244,79 @@ func (r *RabbitMQ) Publish(ctx context.Context, body []byte) error {
 	return err
 }
 
+// PublishDelayed publishes body to a delay queue, from which the broker moves
+// it to the exchange once its TTL has passed.
+func (r *RabbitMQ) PublishDelayed(ctx context.Context, body []byte, delay time.Duration) error {
+	queue, err := r.delayQueue(rmqDelay(delay))
+	if err != nil {
+		return fmt.Errorf("failed to declare RabbitMQ delay queue: %w", err)
+	}
+	return r.publisher.PublishWithContext(
+		ctx,
+		body,
+		[]string{queue},
+		rabbitmq.WithPublishOptionsContentType("application/octet-stream"),
+		rabbitmq.WithPublishOptionsExchange(""),
+		rabbitmq.WithPublishOptionsPersistentDelivery,
+	)
+}
+
+// rmqDelay returns the longest delay queue TTL not above d, or the shortest.
+func rmqDelay(d time.Duration) time.Duration {
+	ttl := rmqDelays[0]
+	for _, t := range rmqDelays {
+		if t <= d {
+			ttl = t
+		}
+	}
+	return ttl
+}
+
+// delayQueue declares the queue holding messages for ttl, once.
+func (r *RabbitMQ) delayQueue(ttl time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if name, ok := r.delayQueues[ttl]; ok {
+		return name, nil
+	}
+	if r.monitor == nil || r.monitor.IsClosed() {
+		return "", amqp.ErrClosed
+	}
+	ch, err := r.monitor.Channel()
+	if err != nil {
+		return "", err
+	}
+	defer ch.Close()
+
+	name := fmt.Sprintf("%s.delay.%s", r.QueueName, ttl)
+	_, err = ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             ttl.Milliseconds(),
+		"x-dead-letter-exchange":    r.ExchangeName,
+		"x-dead-letter-routing-key": r.RoutingKey,
+	})
+	if err != nil {
+		return "", err
+	}
+	r.delayQueues[ttl] = name
+	return name, nil
+}
+
+// StartConsumer hands every message to processor until ctx is done. It then
+// requeues messages not yet handed over and waits for the processor to finish
+// with the current ones, so that they are acknowledged before the channel is
+// closed.
 func (r *RabbitMQ) StartConsumer(ctx context.Context, processor func(payload interface{}) error) error {
 	// Check if the context is already done
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
+	var dr drain
 	consumeFunc := func(d rabbitmq.Delivery) rabbitmq.Action {
 		err := processor(d.Body)
 		if err != nil {
-			e := err.(*Error)
-			if e.IsFatal() {
+			if isFatal(err) {
 				return rabbitmq.NackDiscard
 			}
 			return rabbitmq.NackRequeue
@@ -96,7 +326,7 @@ func (r *RabbitMQ) StartConsumer(ctx context.Context, processor func(payload int
 
 	consumer, err := rabbitmq.NewConsumer(
 		r.conn,
-		consumeFunc,
+		dr.wrap(consumeFunc),
 		r.QueueName,
 		rabbitmq.WithConsumerOptionsRoutingKey(r.RoutingKey),
 		rabbitmq.WithConsumerOptionsExchangeName(r.ExchangeName),
@@ -107,6 +337,114 @@ func (r *RabbitMQ) StartConsumer(ctx context.Context, processor func(payload int
 	defer consumer.Close()
 
 	<-ctx.Done()
+	dr.wait()
+
+	return nil
+}
+
+func (r *RabbitMQ) PublishDeadLetter(ctx context.Context, dl *model.DeadLetter) error {
+	body, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return r.publisher.PublishWithContext(
+		ctx,
+		body,
+		[]string{r.DeadLetterRoutingKey},
+		rabbitmq.WithPublishOptionsContentType("application/json"),
+		rabbitmq.WithPublishOptionsExchange(r.DeadLetterExchangeName),
+		rabbitmq.WithPublishOptionsPersistentDelivery,
+	)
+}
+
+// StartDeadLetterConsumer hands every dead letter to processor. Dead letters
+// the processor fails on stay in the queue, unless the failure is fatal.
+// Messages that are not dead letters are discarded, as they would otherwise
+// be redelivered forever.
+func (r *RabbitMQ) StartDeadLetterConsumer(ctx context.Context, processor func(dl *model.DeadLetter) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var dr drain
+	consumer, err := rabbitmq.NewConsumer(
+		r.conn,
+		dr.wrap(deadLetterHandler(processor)),
+		r.DeadLetterQueueName,
+		rabbitmq.WithConsumerOptionsQueueDurable,
+		rabbitmq.WithConsumerOptionsRoutingKey(r.DeadLetterRoutingKey),
+		rabbitmq.WithConsumerOptionsExchangeName(r.DeadLetterExchangeName),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start RabbitMQ dead-letter consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	<-ctx.Done()
+	dr.wait()
 
 	return nil
 }
+
+func deadLetterHandler(processor func(dl *model.DeadLetter) error) rabbitmq.Handler {
+	return func(d rabbitmq.Delivery) rabbitmq.Action {
+		dl := &model.DeadLetter{}
+		if err := json.Unmarshal(d.Body, dl); err != nil {
+			slog.Error("failed to decode dead letter, discarding it", slog.String("message-id", d.MessageId), slog.Any("err", err))
+			return rabbitmq.NackDiscard
+		}
+		if err := processor(dl); err != nil {
+			if isFatal(err) {
+				return rabbitmq.NackDiscard
+			}
+			return rabbitmq.NackRequeue
+		}
+		return rabbitmq.Ack
+	}
+}
+
+// Close stops the publisher and closes the connections to the broker.
+func (r *RabbitMQ) Close() error {
+	r.publisher.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(r.done)
+	if r.monitor != nil && !r.monitor.IsClosed() {
+		if err := r.monitor.Close(); err != nil {
+			return err
+		}
+	}
+	return r.conn.Close()
+}
+
+// drain lets a consumer stop taking messages while the ones being handled
+// finish. go-rabbitmq closes the channel as soon as a consumer is closed,
+// after which in-flight messages can no longer be acknowledged.
+type drain struct {
+	mu       sync.Mutex
+	stopping bool
+	active   sync.WaitGroup
+}
+
+// wrap returns a handler that requeues messages once the drain has started.
+func (d *drain) wrap(handler rabbitmq.Handler) rabbitmq.Handler {
+	return func(msg rabbitmq.Delivery) rabbitmq.Action {
+		d.mu.Lock()
+		if d.stopping {
+			d.mu.Unlock()
+			return rabbitmq.NackRequeue
+		}
+		d.active.Add(1)
+		d.mu.Unlock()
+		defer d.active.Done()
+		return handler(msg)
+	}
+}
+
+// wait starts the drain and returns once no message is being handled.
+func (d *drain) wait() {
+	d.mu.Lock()
+	d.stopping = true
+	d.mu.Unlock()
+	d.active.Wait()
+}
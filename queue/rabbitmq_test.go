@@ -2,11 +2,15 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
+	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/model"
+	"github.com/wagslane/go-rabbitmq"
 )
 
 func TestNewRabbitMQ(t *testing.T) {
@@ -84,3 +88,90 @@ func TestRabbitMQ_StartConsumer(t *testing.T) {
 		}
 	}
 }
+
+func TestRabbitMQ_DeadLetter(t *testing.T) {
+	opts := &RabbitMQOpts{
+		Host:     "localhost",
+		Port:     5672,
+		Username: "hookie",
+		Password: "hookie",
+	}
+
+	rmq, err := NewRabbitMQ(opts)
+	require.NoError(t, err)
+
+	err = rmq.PublishDeadLetter(context.TODO(), &model.DeadLetter{RequestID: "1", Attempts: 3, Payload: []byte("payload")})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	go func() {
+		time.Sleep(1 * time.Second)
+		cancel()
+	}()
+
+	received := make(chan *model.DeadLetter, 1)
+	err = rmq.StartDeadLetterConsumer(ctx, func(dl *model.DeadLetter) error {
+		received <- dl
+		return nil
+	})
+	require.NoError(t, err)
+
+	select {
+	case dl := <-received:
+		assert.Equal(t, 3, dl.Attempts)
+		assert.Equal(t, []byte("payload"), dl.Payload)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive dead letter")
+	}
+}
+
+func TestRMQDelay(t *testing.T) {
+	assert.Equal(t, time.Second, rmqDelay(0))
+	assert.Equal(t, time.Second, rmqDelay(4*time.Second))
+	assert.Equal(t, 5*time.Second, rmqDelay(5*time.Second))
+	assert.Equal(t, time.Minute, rmqDelay(3*time.Minute))
+	assert.Equal(t, time.Hour, rmqDelay(3*time.Hour))
+}
+
+func TestDeadLetterHandler(t *testing.T) {
+	var processed []*model.DeadLetter
+	var fail error
+	handler := deadLetterHandler(func(dl *model.DeadLetter) error {
+		processed = append(processed, dl)
+		return fail
+	})
+	delivery := func(body string) rabbitmq.Delivery {
+		return rabbitmq.Delivery{Delivery: amqp.Delivery{Body: []byte(body)}}
+	}
+
+	assert.Equal(t, rabbitmq.Ack, handler(delivery(`{"RequestID":"abc"}`)))
+	require.Len(t, processed, 1)
+	assert.Equal(t, "abc", processed[0].RequestID)
+
+	assert.Equal(t, rabbitmq.NackDiscard, handler(delivery("not json")), "undecodable messages are not redelivered")
+	assert.Len(t, processed, 1)
+
+	fail = errors.New("unavailable")
+	assert.Equal(t, rabbitmq.NackRequeue, handler(delivery(`{}`)))
+	fail = NewError(fail, true)
+	assert.Equal(t, rabbitmq.NackDiscard, handler(delivery(`{}`)))
+}
+
+func TestRabbitMQ_PingReportsMonitorState(t *testing.T) {
+	r := &RabbitMQ{done: make(chan struct{})}
+	assert.NoError(t, r.Ping(context.Background()))
+
+	require.True(t, r.setMonitor(nil, amqp.ErrClosed))
+	assert.ErrorIs(t, r.Ping(context.Background()), amqp.ErrClosed)
+
+	require.True(t, r.setMonitor(nil, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, r.Ping(ctx), context.Canceled)
+
+	close(r.done)
+	assert.False(t, r.setMonitor(nil, amqp.ErrClosed), "closed")
+	assert.NoError(t, r.Ping(context.Background()))
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBuckets bounds memory use when limits are keyed by request values.
+// Beyond it, buckets that have refilled are dropped since they are no
+// different from new ones.
+const maxBuckets = 10000
+
+var now = time.Now
+
+// Limiter holds named token buckets. A bucket's settings are fixed when it is
+// created, so callers using one name with different settings, e.g. after the
+// rules were reloaded, get separate buckets.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+}
+
+type bucketKey struct {
+	name  string
+	rate  float64
+	burst int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+func New() *Limiter {
+	return &Limiter{buckets: make(map[bucketKey]*bucket)}
+}
+
+// Allow takes a token from the named bucket if one is available. Otherwise
+// it returns false and how long until the next token.
+func (l *Limiter) Allow(name string, rate float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(name, rate, burst)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, b.wait(1 - b.tokens)
+}
+
+// bucket returns the refilled bucket for name and settings, creating it full.
+// Must be called with mu held.
+func (l *Limiter) bucket(name string, rate float64, burst int) *bucket {
+	t := now()
+	key := bucketKey{name: name, rate: rate, burst: burst}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(t)
+		}
+		b = &bucket{tokens: float64(burst), last: t, rate: rate, burst: float64(burst)}
+		l.buckets[key] = b
+	}
+	b.refill(t)
+	return b
+}
+
+func (l *Limiter) sweep(t time.Time) {
+	for key, b := range l.buckets {
+		b.refill(t)
+		if b.tokens >= b.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (b *bucket) refill(t time.Time) {
+	if elapsed := t.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		b.last = t
+	}
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// wait returns how long it takes to refill the given number of tokens.
+func (b *bucket) wait(tokens float64) time.Duration {
+	return time.Duration(tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	t0 := time.Unix(1700000000, 0)
+	now = func() time.Time { return t0 }
+
+	l := New()
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("shop-a", 2, 3)
+		assert.True(t, ok)
+	}
+	ok, retryAfter := l.Allow("shop-a", 2, 3)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// Other buckets are unaffected.
+	ok, _ = l.Allow("shop-b", 2, 3)
+	assert.True(t, ok)
+
+	now = func() time.Time { return t0.Add(500 * time.Millisecond) }
+	ok, _ = l.Allow("shop-a", 2, 3)
+	assert.True(t, ok)
+	ok, _ = l.Allow("shop-a", 2, 3)
+	assert.False(t, ok)
+}
+
+func TestLimiter_AllowSettingsPerBucket(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	t0 := time.Unix(1700000000, 0)
+	now = func() time.Time { return t0 }
+
+	// A name used with other settings does not change the existing bucket.
+	l := New()
+	ok, _ := l.Allow("upstream", 1, 1)
+	assert.True(t, ok)
+	ok, retryAfter := l.Allow("upstream", 1, 1)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	for i := 0; i < 5; i++ {
+		ok, _ = l.Allow("upstream", 100, 5)
+		assert.True(t, ok)
+	}
+	ok, retryAfter = l.Allow("upstream", 1, 1)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	t0 := time.Unix(1700000000, 0)
+	now = func() time.Time { return t0 }
+
+	l := New()
+	for i := 0; i < maxBuckets; i++ {
+		l.Allow(time.Duration(i).String(), 1, 1)
+	}
+	assert.Len(t, l.buckets, maxBuckets)
+
+	now = func() time.Time { return t0.Add(time.Second) }
+	l.Allow("new", 1, 1)
+	assert.Len(t, l.buckets, 1)
+}
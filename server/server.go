@@ -1,62 +1,276 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/thebluefowl/hookie/archive"
+	"github.com/thebluefowl/hookie/dedup"
+	"github.com/thebluefowl/hookie/delivery"
 	"github.com/thebluefowl/hookie/forwarder"
+	"github.com/thebluefowl/hookie/health"
+	"github.com/thebluefowl/hookie/metrics"
 	"github.com/thebluefowl/hookie/model"
+	"github.com/thebluefowl/hookie/proxyutils"
+	"github.com/thebluefowl/hookie/ratelimit"
 	"golang.org/x/exp/slog"
 )
 
 // Server represents the main HTTP server struct, holding necessary ruleset actions and the publisher.
 type Server struct {
-	rulesetActions []model.Rule
+	rulesetActions atomic.Pointer[[]model.Rule]
 	forwarders     map[string]forwarder.Forwarder
+	limiter        *ratelimit.Limiter
+	dedup          dedup.Store
+	records        delivery.Store
+	archive        archive.Archive
+	probes         *health.Probes
+	stats          stats
+
+	http *http.Server
+	// background tracks fan-out and mirror deliveries, which outlive the
+	// request that started them.
+	background sync.WaitGroup
+	// mirrors holds a slot for every shadow copy in flight.
+	mirrors chan struct{}
 }
 
+const (
+	// maxMirrors bounds the shadow copies in flight, beyond which they are
+	// dropped rather than piling up behind a slow mirror.
+	maxMirrors = 100
+	// defaultMirrorTimeout bounds shadow copies whose action sets no timeout.
+	defaultMirrorTimeout = 10 * time.Second
+)
+
+const (
+	// RequestIDHeader is set on every response, so that callers can look up
+	// the delivery on the admin port.
+	RequestIDHeader = "X-Hookie-Request-Id"
+	// DuplicateHeader is set on responses to requests recognised as redeliveries.
+	DuplicateHeader = "X-Hookie-Duplicate"
+)
+
 // New creates a new instance of the Server.
 func New(rulesetActions []model.Rule, instantForwarder *forwarder.InstantForwarder, queuedForwarder *forwarder.QueuedForwarder) *Server {
 	fallbackForwarder := forwarder.NewFallbackForwarder(instantForwarder, queuedForwarder)
-	return &Server{
-		rulesetActions: rulesetActions,
+	s := &Server{
 		forwarders: map[string]forwarder.Forwarder{
 			model.DeliveryModeInstant:  instantForwarder,
 			model.DeliveryModeQueued:   queuedForwarder,
 			model.DeliveryModeFallback: fallbackForwarder,
 		},
+		limiter: ratelimit.New(),
+		dedup:   dedup.NewMemory(dedup.DefaultCapacity),
+		probes:  health.New(),
+		mirrors: make(chan struct{}, maxMirrors),
+	}
+	s.http = &http.Server{Handler: s}
+	s.probes.Add("rules", s.rulesLoaded)
+	s.SetRules(rulesetActions)
+	return s
+}
+
+// SetRules atomically replaces the rules used for new requests. Requests that
+// already matched a rule finish with it.
+func (s *Server) SetRules(rules []model.Rule) {
+	s.rulesetActions.Store(&rules)
+}
+
+// SetDedupStore replaces the in-memory store of idempotency keys, e.g. with
+// one shared between instances.
+func (s *Server) SetDedupStore(store dedup.Store) {
+	s.dedup = store
+}
+
+// SetDeliveryStore sets where the delivery records of requests are kept.
+// Without one, none are.
+func (s *Server) SetDeliveryStore(store delivery.Store) {
+	s.records = store
+}
+
+// SetArchive sets where incoming requests are archived for replay. Without
+// one, none are.
+func (s *Server) SetArchive(a archive.Archive) {
+	s.archive = a
+}
+
+// Probes returns the liveness and readiness probes answered on the webhook
+// port, to which the checks of the components the server depends on can be
+// added.
+func (s *Server) Probes() *health.Probes {
+	return s.probes
+}
+
+func (s *Server) rulesLoaded(ctx context.Context) error {
+	if len(s.Rules()) == 0 {
+		return errors.New("no rules loaded")
 	}
+	return nil
+}
+
+// Stats returns a snapshot of the request counters per rule.
+func (s *Server) Stats() Stats {
+	return s.stats.snapshot()
+}
 
+// Rules returns the rules currently in use.
+func (s *Server) Rules() []model.Rule {
+	return *s.rulesetActions.Load()
 }
 
 // ServeHTTP is the HTTP request handler for the server.
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.probes.Handles(req.URL.Path) {
+		s.probes.ServeHTTP(w, req)
+		return
+	}
 
 	requestID := uuid.New().String()
 	ctx := context.WithValue(req.Context(), model.ContextKey("request-id"), requestID)
+	w.Header().Set(RequestIDHeader, requestID)
 
+	// Counted once the rule is known.
+	var ruleName string
+	defer func() { metrics.IncomingRequests.WithLabelValues(ruleName).Inc() }()
 	slog.Info("INCOMING-REQUEST", slog.Any("request-id", requestID), slog.Any("method", req.Method), slog.Any("url", req.URL.String()))
 
+	var entry *archive.Entry
+	if s.archive != nil {
+		var err error
+		if entry, err = s.capture(requestID, req); err != nil {
+			slog.Error("failed to read request", slog.String("request-id", requestID), slog.Any("err", err))
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		w = sw
+		defer func() {
+			entry.Status = sw.status
+			if err := s.archive.Append(context.Background(), entry); err != nil {
+				slog.Error("failed to archive request", slog.String("request-id", requestID), slog.Any("err", err))
+			}
+		}()
+	}
+
 	r, err := s.matchRule(req)
 	if err != nil {
+		s.stats.noMatch()
+		metrics.RuleNoMatches.Inc()
 		slog.Error("failed to select ruleset-action", slog.Any("err", err))
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 
 	slog.Info("MATCHING-RULE", slog.String("request-id", requestID), slog.Any("rule", r.Name))
+	ruleName = r.Name
+	ctx = context.WithValue(ctx, model.ContextKey("rule"), r.Name)
+	if entry != nil {
+		entry.Rule = r.Name
+	}
+	s.stats.matched(r.Name)
+	metrics.RuleMatches.WithLabelValues(r.Name).Inc()
 
+	if r.Verify != nil {
+		if err := s.verify(req, r); err != nil {
+			s.stats.failed(r.Name)
+			metrics.VerificationFailures.WithLabelValues(r.Name).Inc()
+			slog.Error("VERIFICATION-FAIL", slog.String("request-id", requestID), slog.String("rule", r.Name), slog.Any("err", err))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var idempotencyKey string
+	if r.Dedup != nil {
+		if key, ok := r.Dedup.IdempotencyKey(r.Name, req); ok {
+			status, reserved, err := s.dedup.Reserve(ctx, key, r.Dedup.Expiry())
+			switch {
+			case err != nil:
+				slog.Error("failed to reserve idempotency key", slog.String("request-id", requestID), slog.Any("err", err))
+			case !reserved:
+				metrics.DuplicateRequests.WithLabelValues(r.Name).Inc()
+				w.Header().Set(DuplicateHeader, "true")
+				if status == dedup.Pending {
+					// The first delivery may still fail, so the provider is
+					// asked to retry rather than told it succeeded.
+					slog.Info("DUPLICATE-REQUEST-PENDING", slog.String("request-id", requestID), slog.String("rule", r.Name))
+					http.Error(w, "request with the same idempotency key in progress", http.StatusConflict)
+					return
+				}
+				slog.Info("DUPLICATE-REQUEST", slog.String("request-id", requestID), slog.String("rule", r.Name), slog.Int("status-code", status))
+				w.WriteHeader(status)
+				return
+			default:
+				idempotencyKey = key
+			}
+		}
+	}
+	// Until the outcome is recorded, the key is released on every way out so
+	// that the provider's retry is forwarded again.
+	defer func() {
+		if idempotencyKey != "" {
+			if err := s.dedup.Release(ctx, idempotencyKey); err != nil {
+				slog.Error("failed to release idempotency key", slog.String("request-id", requestID), slog.Any("err", err))
+			}
+		}
+	}()
+
+	if r.RateLimit != nil {
+		rl := r.RateLimit
+		if ok, retryAfter := s.limiter.Allow(rl.Bucket(r.Name, req), rl.Rate, rl.Burst); !ok {
+			if rl.Exceeded != model.RateLimitQueue {
+				metrics.RateLimitedRequests.WithLabelValues(r.Name, "rejected").Inc()
+				slog.Warn("RATE-LIMITED", slog.String("request-id", requestID), slog.String("rule", r.Name))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			metrics.RateLimitedRequests.WithLabelValues(r.Name, "queued").Inc()
+			slog.Warn("RATE-LIMITED-QUEUED", slog.String("request-id", requestID), slog.String("rule", r.Name))
+			diverted := *r
+			diverted.Action = r.Action.Queued()
+			r = &diverted
+		}
+	}
+
+	delivery.Update(ctx, s.records, requestID, delivery.Received(r.Name))
 	res, err := s.process(ctx, req, r)
 	if err != nil {
+		s.stats.failed(r.Name)
 		slog.Error("failed to process request", slog.Any("err", err))
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	// Failed deliveries are not remembered so that the provider's retry is
+	// forwarded again.
+	if idempotencyKey != "" && res.StatusCode < http.StatusInternalServerError {
+		if err := s.dedup.Set(ctx, idempotencyKey, res.StatusCode, r.Dedup.Expiry()); err != nil {
+			slog.Error("failed to record idempotency key", slog.String("request-id", requestID), slog.Any("err", err))
+		} else {
+			idempotencyKey = ""
+		}
+	}
 
+	if ct := res.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
 	w.WriteHeader(res.StatusCode)
 	if res.Body != nil {
 		_, err := io.Copy(w, res.Body)
@@ -66,26 +280,260 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// ListenAndServe starts the server on the given address.
+// capture returns the archive entry of the request as received.
+func (s *Server) capture(requestID string, req *http.Request) (*archive.Entry, error) {
+	sr, err := proxyutils.NewSerializableRequest(requestID, req)
+	if err != nil {
+		return nil, err
+	}
+	return &archive.Entry{ReceivedAt: time.Now(), ClientSubject: model.ClientSubject(req), Request: sr}, nil
+}
+
+// statusWriter remembers the status written to the caller.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Replay delivers an archived request to the primary upstream of the rule it
+// matches now, as received with the client certificate subject recorded.
+// Verification, deduplication and rate limits applied when it was first
+// received and are skipped, as are fan-out and mirror upstreams. A non-empty
+// mode overrides the action's delivery mode.
+func (s *Server) Replay(ctx context.Context, e *archive.Entry, mode string) (string, *http.Response, error) {
+	if e.ClientSubject != "" {
+		ctx = model.WithClientSubject(ctx, e.ClientSubject)
+	}
+	req := e.Request.Request().WithContext(ctx)
+	r, err := s.matchRule(req)
+	if err != nil {
+		return "", nil, err
+	}
+	ctx = context.WithValue(ctx, model.ContextKey("request-id"), e.RequestID())
+	ctx = context.WithValue(ctx, model.ContextKey("rule"), r.Name)
+	req = req.WithContext(ctx)
+
+	action := r.Action
+	if action.Weighted() {
+		action, _ = action.Pick(req)
+	}
+	primary, _ := action.Targets()
+	if mode != "" {
+		p := *primary
+		p.DeliveryMode = mode
+		primary = &p
+	}
+	res, err := s.forward(delivery.WithTracking(ctx), req, primary)
+	return r.Name, res, err
+}
+
+// ListenAndServe starts the server on the given address. It returns
+// http.ErrServerClosed once Shutdown has been called.
 func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s)
+	s.http.Addr = addr
+	return s.http.ListenAndServe()
+}
+
+// ListenAndServeTLS is ListenAndServe over TLS with the given config, which
+// must provide the certificate.
+func (s *Server) ListenAndServeTLS(addr string, config *tls.Config) error {
+	s.http.Addr = addr
+	s.http.TLSConfig = config
+	return s.http.ListenAndServeTLS("", "")
+}
+
+// Shutdown stops accepting connections and waits for the requests being
+// served, and the fan-out and mirror deliveries they started, to complete
+// until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.http.Shutdown(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background deliveries still running: %w", ctx.Err())
+	}
 }
 
 // process handles the incoming request by matching it to a ruleset and then processing it based on the delivery mode.
+// Secondary upstreams are delivered in the background; only the primary's response is returned.
 func (s *Server) process(ctx context.Context, req *http.Request, ra *model.Rule) (*http.Response, error) {
 	if ra != nil {
-		fw, ok := s.forwarders[ra.Action.DeliveryMode]
-		if !ok {
-			return nil, model.ErrUnknownDeliveryMode
+		action := ra.Action
+		if m := action.Mirrored(); m != nil {
+			s.mirror(ctx, req, ra.Name, m)
 		}
-		return fw.Forward(ctx, req, ra.Action.URL())
+		if action.Weighted() {
+			var sel model.Selection
+			action, sel = action.Pick(req)
+			metrics.UpstreamSelections.WithLabelValues(ra.Name, sel.Upstream).Inc()
+			requestID, _ := ctx.Value(model.ContextKey("request-id")).(string)
+			slog.Info("UPSTREAM-SELECTED", slog.String("request-id", requestID), slog.String("rule", ra.Name), slog.String("upstream", sel.Upstream), slog.Bool("sticky", sel.Sticky))
+		}
+		primary, others := action.Targets()
+		if len(others) > 0 {
+			if err := s.fanOut(ctx, req, others); err != nil {
+				return nil, err
+			}
+		}
+		return s.forward(delivery.WithTracking(ctx), req, primary)
 	}
 	return nil, nil
 }
 
+// verify checks the request signature required by the rule against the raw body.
+func (s *Server) verify(req *http.Request, r *model.Rule) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	return r.Verify.Verify(req, body)
+}
+
+func (s *Server) forward(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error) {
+	fw, ok := s.forwarders[action.DeliveryMode]
+	if !ok {
+		return nil, model.ErrUnknownDeliveryMode
+	}
+	if action.Transform != nil {
+		requestID, _ := ctx.Value(model.ContextKey("request-id")).(string)
+		out, err := action.Transform.Apply(req, requestID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform request: %w", err)
+		}
+		req = out
+	}
+	return fw.Forward(ctx, req, action)
+}
+
+// fanOut delivers a copy of req to each of the given actions independently of
+// the caller's request lifecycle.
+func (s *Server) fanOut(ctx context.Context, req *http.Request, actions []*model.Action) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	requestID := ctx.Value(model.ContextKey("request-id")).(string)
+	for _, action := range actions {
+		out := detach(ctx, req, body)
+
+		s.background.Add(1)
+		go func(action *model.Action) {
+			defer s.background.Done()
+			res, err := s.forward(out.Context(), out, action)
+			if err != nil {
+				slog.Error("FANOUT-FAIL", slog.String("request-id", requestID), slog.String("upstream", action.UpstreamHost), slog.Any("err", err))
+				return
+			}
+			if res.Body != nil {
+				res.Body.Close()
+			}
+			slog.Info("FANOUT-SUCCESS", slog.String("request-id", requestID), slog.String("upstream", action.UpstreamHost), slog.Int("status-code", res.StatusCode))
+		}(action)
+	}
+	return nil
+}
+
+// mirror sends a shadow copy of req to the mirror action in the background,
+// unless maxMirrors are already in flight. Its outcome is only logged and
+// counted, never reported to the caller.
+func (s *Server) mirror(ctx context.Context, req *http.Request, rule string, action *model.Action) {
+	requestID := ctx.Value(model.ContextKey("request-id")).(string)
+	select {
+	case s.mirrors <- struct{}{}:
+	default:
+		metrics.MirrorRequests.WithLabelValues(rule, metrics.MirrorDropped).Inc()
+		slog.Warn("MIRROR-DROPPED", slog.String("request-id", requestID), slog.String("upstream", action.UpstreamHost))
+		return
+	}
+	body, err := readBody(req)
+	if err != nil {
+		<-s.mirrors
+		metrics.MirrorRequests.WithLabelValues(rule, metrics.Result(err)).Inc()
+		slog.Error("MIRROR-FAIL", slog.String("request-id", requestID), slog.String("upstream", action.UpstreamHost), slog.Any("err", err))
+		return
+	}
+	out := detach(ctx, req, body)
+	timeout := action.Timeout()
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer func() { <-s.mirrors }()
+
+		ctx, cancel := context.WithTimeout(out.Context(), timeout)
+		defer cancel()
+		res, err := s.forward(ctx, out.WithContext(ctx), action)
+		if err == nil {
+			if res.Body != nil {
+				res.Body.Close()
+			}
+			if res.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("mirror responded %d", res.StatusCode)
+			}
+		}
+		metrics.MirrorRequests.WithLabelValues(rule, metrics.Result(err)).Inc()
+		if err != nil {
+			slog.Error("MIRROR-FAIL", slog.String("request-id", requestID), slog.String("upstream", action.UpstreamHost), slog.Any("err", err))
+			return
+		}
+		slog.Info("MIRROR-SUCCESS", slog.String("request-id", requestID), slog.String("upstream", action.UpstreamHost), slog.Int("status-code", res.StatusCode))
+	}()
+}
+
+// detach returns a copy of req with its own body and a context that carries
+// the request ID and rule but not the caller's cancellation.
+func detach(ctx context.Context, req *http.Request, body []byte) *http.Request {
+	dctx := context.WithValue(context.Background(), model.ContextKey("request-id"), ctx.Value(model.ContextKey("request-id")))
+	dctx = context.WithValue(dctx, model.ContextKey("rule"), ctx.Value(model.ContextKey("rule")))
+	out := req.Clone(dctx)
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	return out
+}
+
+// readBody buffers the request body and rewinds req.Body so it can be read again.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Body.Close(); err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(buf))
+	return buf, nil
+}
+
 // matchingRulesetAction finds the first matching ruleset action for a given request.
 func (s *Server) matchRule(req *http.Request) (*model.Rule, error) {
-	for _, ra := range s.rulesetActions {
+	for _, ra := range s.Rules() {
 		res, err := ra.TriggerSet.Match(req)
 		if err != nil {
 			return nil, err
@@ -1 +1,654 @@
 package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thebluefowl/hookie/archive"
+	"github.com/thebluefowl/hookie/delivery"
+	"github.com/thebluefowl/hookie/forwarder"
+	"github.com/thebluefowl/hookie/metrics"
+	"github.com/thebluefowl/hookie/model"
+	"gopkg.in/yaml.v2"
+)
+
+type fakePublisher struct {
+	published chan []byte
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, payload []byte) error {
+	f.published <- payload
+	return nil
+}
+
+func pathRule(t *testing.T, name, path string, action *model.Action) model.Rule {
+	ts := &model.TriggerSet{}
+	err := yaml.Unmarshal([]byte(fmt.Sprintf(`triggers:
+  - property: path
+    comparator: equal
+    value:
+      value: %s`, path)), ts)
+	require.NoError(t, err)
+	return model.Rule{Name: name, TriggerSet: ts, Action: action}
+}
+
+func newServer(rules []model.Rule, publisher *fakePublisher) *Server {
+	return New(rules, forwarder.NewInstantForwarder(nil, nil, nil), forwarder.NewQueuedForwarder(publisher, nil))
+}
+
+func TestServer_FanOut(t *testing.T) {
+	secondary := make(chan string, 1)
+	analytics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		secondary <- string(body)
+	}))
+	defer analytics.Close()
+
+	payments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "event", string(body))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("primary"))
+	}))
+	defer payments.Close()
+
+	publisher := &fakePublisher{published: make(chan []byte, 1)}
+	s := newServer([]model.Rule{
+		pathRule(t, "stripe", "/stripe", &model.Action{
+			DeliveryMode: model.DeliveryModeInstant,
+			Upstreams: []model.Upstream{
+				{URL: analytics.URL},
+				{URL: payments.URL, Primary: true},
+				{URL: "http://archive", DeliveryMode: model.DeliveryModeQueued},
+			},
+		}),
+	}, publisher)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/stripe", strings.NewReader("event")))
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "primary", rec.Body.String())
+
+	select {
+	case body := <-secondary:
+		assert.Equal(t, "event", body)
+	case <-time.After(2 * time.Second):
+		t.Fatal("secondary upstream was not called")
+	}
+	select {
+	case payload := <-publisher.published:
+		assert.Contains(t, string(payload), "http://archive/stripe")
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued upstream was not published")
+	}
+}
+
+func TestServer_DeliveryStatus(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	records := delivery.NewMemory(0)
+	publisher := &fakePublisher{published: make(chan []byte, 1)}
+	s := New([]model.Rule{
+		pathRule(t, "instant", "/instant", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant}),
+		pathRule(t, "queued", "/queued", &model.Action{UpstreamHost: "http://consumer", DeliveryMode: model.DeliveryModeQueued}),
+	}, forwarder.NewInstantForwarder(nil, nil, records), forwarder.NewQueuedForwarder(publisher, records))
+	s.SetDeliveryStore(records)
+	ctx := context.Background()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/instant", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	id := rec.Header().Get(RequestIDHeader)
+	require.NotEmpty(t, id)
+	r, err := records.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "instant", r.Rule)
+	assert.Equal(t, delivery.StateDelivered, r.State)
+	assert.Equal(t, http.StatusNoContent, r.LastStatus)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/queued", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var accepted forwarder.Accepted
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accepted))
+	assert.Equal(t, rec.Header().Get(RequestIDHeader), accepted.RequestID)
+	assert.Equal(t, delivery.StateQueued, accepted.State)
+	r, err = records.Get(ctx, accepted.RequestID)
+	require.NoError(t, err)
+	assert.Equal(t, delivery.StateQueued, r.State)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/nowhere", nil))
+	assert.NotEmpty(t, rec.Header().Get(RequestIDHeader))
+}
+
+func TestServer_ArchiveAndReplay(t *testing.T) {
+	status := http.StatusBadGateway
+	received := make(chan string, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.URL.Path + " " + string(body)
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+
+	a, err := archive.NewJSONL(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer a.Close()
+
+	s := newServer([]model.Rule{
+		pathRule(t, "hook", "/hook", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant}),
+	}, nil)
+	s.SetArchive(a)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("event")))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "/hook event", <-received)
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/nowhere", nil))
+
+	var entries []*archive.Entry
+	require.NoError(t, a.Scan(context.Background(), archive.Filter{}, func(e *archive.Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 2)
+	e := entries[0]
+	assert.Equal(t, rec.Header().Get(RequestIDHeader), e.RequestID())
+	assert.Equal(t, "hook", e.Rule)
+	assert.Equal(t, http.StatusBadGateway, e.Status)
+	assert.Equal(t, "event", string(e.Request.Body))
+	assert.Empty(t, entries[1].Rule)
+
+	status = http.StatusOK
+	rule, res, err := s.Replay(context.Background(), e, "")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "hook", rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "/hook event", <-received)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	s := newServer([]model.Rule{
+		pathRule(t, "hook", "/hook", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant}),
+	}, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(addr) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	require.Eventually(t, func() bool {
+		res, err := http.Get("http://" + addr + "/nowhere")
+		if err == nil {
+			res.Body.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	go func() {
+		res, err := http.Post("http://"+addr+"/hook", "text/plain", strings.NewReader("event"))
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		results <- result{body: string(body), err: err}
+	}()
+
+	// Shutdown waits for the request in progress.
+	time.Sleep(100 * time.Millisecond)
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown did not wait for the request in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-shutdown)
+	r := <-results
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+	assert.ErrorIs(t, <-served, http.ErrServerClosed)
+}
+
+func TestServer_Probes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	readiness := func(s *Server) (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var r struct{ Checks map[string]string }
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &r))
+		return rec.Code, r.Checks
+	}
+
+	s := newServer(nil, nil)
+	code, checks := readiness(s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "no rules loaded", checks["rules"])
+
+	// Probes are answered even when a rule would match them.
+	s.SetRules([]model.Rule{
+		pathRule(t, "health", "/healthz", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant}),
+	})
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok\n", rec.Body.String())
+
+	code, checks = readiness(s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"rules": "ok"}, checks)
+
+	s.Probes().Add("queue", func(ctx context.Context) error { return errors.New("failed to connect to RabbitMQ") })
+	code, checks = readiness(s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]string{"rules": "ok", "queue": "failed to connect to RabbitMQ"}, checks)
+}
+
+func TestServer_SetRules(t *testing.T) {
+	v1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v1"))
+	}))
+	defer v1.Close()
+	v2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v2"))
+	}))
+	defer v2.Close()
+
+	s := newServer([]model.Rule{
+		pathRule(t, "hook", "/hook", &model.Action{UpstreamHost: v1.URL, DeliveryMode: model.DeliveryModeInstant}),
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hook", nil))
+	assert.Equal(t, "v1", rec.Body.String())
+
+	s.SetRules([]model.Rule{
+		pathRule(t, "hook", "/hook", &model.Action{UpstreamHost: v2.URL, DeliveryMode: model.DeliveryModeInstant}),
+	})
+	assert.Len(t, s.Rules(), 1)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hook", nil))
+	assert.Equal(t, "v2", rec.Body.String())
+}
+
+func TestServer_Verify(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "event", string(body))
+	}))
+	defer upstream.Close()
+
+	rule := pathRule(t, "github", "/github", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant})
+	rule.Verify = &model.Verification{}
+	require.NoError(t, yaml.Unmarshal([]byte("preset: github\nsecret: s3cr3t"), rule.Verify))
+	s := newServer([]model.Rule{rule}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/github", strings.NewReader("event"))
+	req.Header.Set("X-Hub-Signature-256", "sha256=00")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte("event"))
+	req = httptest.NewRequest(http.MethodPost, "/github", strings.NewReader("event"))
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, RuleStats{Matched: 2, Failed: 1}, s.Stats().Rules["github"])
+}
+
+func TestServer_Transform(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/internal/stripe", r.URL.Path)
+		assert.Equal(t, "stripe", r.Header.Get("X-Source"))
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "event", string(body))
+	}))
+	defer upstream.Close()
+
+	action := &model.Action{}
+	require.NoError(t, yaml.Unmarshal([]byte(fmt.Sprintf(`upstream: %s
+delivery_mode: instant
+transform:
+  path:
+    regex: ^/hooks/stripe/v1$
+    replacement: /internal/stripe
+  headers:
+    set:
+      X-Source: stripe
+`, upstream.URL)), action))
+	s := newServer([]model.Rule{pathRule(t, "stripe", "/hooks/stripe/v1", action)}, nil)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hooks/stripe/v1", strings.NewReader("event")))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestServer_Mirror(t *testing.T) {
+	mirrored := make(chan string, 1)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- string(body)
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "event", string(body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	s := newServer([]model.Rule{
+		pathRule(t, "orders", "/orders", &model.Action{
+			UpstreamHost: upstream.URL,
+			DeliveryMode: model.DeliveryModeInstant,
+			Mirror:       shadow.URL,
+		}),
+	}, nil)
+
+	// The slow, failing mirror must neither delay nor change the response.
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("event")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	select {
+	case body := <-mirrored:
+		assert.Equal(t, "event", body)
+	case <-time.After(time.Second):
+		t.Fatal("request was not mirrored")
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	limited := func(name, path, exceeded string) model.Rule {
+		r := pathRule(t, name, path, &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant})
+		r.RateLimit = &model.RateLimit{Rate: 0.1, Burst: 1, Key: &model.RequestKey{Header: "X-Shop"}, Exceeded: exceeded}
+		return r
+	}
+	publisher := &fakePublisher{published: make(chan []byte, 1)}
+	s := newServer([]model.Rule{limited("reject", "/reject", ""), limited("queue", "/queue", model.RateLimitQueue)}, publisher)
+
+	send := func(path, shop string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("event"))
+		req.Header.Set("X-Shop", shop)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, send("/reject", "acme").Code)
+	rec := send("/reject", "acme")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, send("/reject", "globex").Code, "each shop has its own bucket")
+
+	assert.Equal(t, http.StatusOK, send("/queue", "acme").Code)
+	assert.Equal(t, http.StatusAccepted, send("/queue", "acme").Code)
+	select {
+	case <-publisher.published:
+	case <-time.After(time.Second):
+		t.Fatal("request over the limit was not queued")
+	}
+}
+
+func TestServer_Dedup(t *testing.T) {
+	calls := 0
+	status := http.StatusCreated
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+
+	rule := pathRule(t, "github", "/github", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant})
+	rule.Dedup = &model.Dedup{Key: model.RequestKey{Header: "X-GitHub-Delivery"}}
+	s := newServer([]model.Rule{rule}, nil)
+
+	send := func(delivery string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/github", strings.NewReader("event"))
+		req.Header.Set("X-GitHub-Delivery", delivery)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, send("1").Code)
+	rec := send("1")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get(DuplicateHeader))
+	assert.Equal(t, 1, calls)
+
+	// Failed deliveries are forwarded again when retried.
+	status = http.StatusServiceUnavailable
+	assert.Equal(t, http.StatusServiceUnavailable, send("2").Code)
+	status = http.StatusOK
+	assert.Equal(t, http.StatusOK, send("2").Code)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, http.StatusOK, send("2").Code)
+	assert.Equal(t, 3, calls)
+}
+
+func TestServer_DedupPending(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	rule := pathRule(t, "github", "/github", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant})
+	rule.Dedup = &model.Dedup{Key: model.RequestKey{Header: "X-GitHub-Delivery"}}
+	s := newServer([]model.Rule{rule}, nil)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/github", strings.NewReader("event"))
+		req.Header.Set("X-GitHub-Delivery", "1")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- send() }()
+	<-arrived
+
+	// A duplicate arriving while the first is forwarded is not forwarded.
+	rec := send()
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get(DuplicateHeader))
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-first).Code)
+	assert.Equal(t, http.StatusCreated, send().Code)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+type forwarderFunc func(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error)
+
+func (f forwarderFunc) Forward(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error) {
+	return f(ctx, req, action)
+}
+
+func TestServer_ClosesResponseBody(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader("created")}
+	s := newServer([]model.Rule{pathRule(t, "github", "/github", &model.Action{UpstreamHost: "http://upstream", DeliveryMode: model.DeliveryModeInstant})}, nil)
+	s.forwarders[model.DeliveryModeInstant] = forwarderFunc(func(ctx context.Context, req *http.Request, action *model.Action) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusCreated, Header: http.Header{}, Body: body}, nil
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/github", strings.NewReader("event")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "created", rec.Body.String())
+	assert.True(t, body.closed)
+}
+
+func TestServer_IncomingRequestsByRule(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	s := newServer([]model.Rule{pathRule(t, "counted", "/counted", &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant})}, nil)
+
+	matched, unmatched := metrics.IncomingRequests.WithLabelValues("counted"), metrics.IncomingRequests.WithLabelValues("")
+	before, beforeUnmatched := testutil.ToFloat64(matched), testutil.ToFloat64(unmatched)
+	for _, path := range []string{"/counted", "/counted", "/other"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader("event")))
+	}
+	assert.Equal(t, 2.0, testutil.ToFloat64(matched)-before)
+	assert.Equal(t, 1.0, testutil.ToFloat64(unmatched)-beforeUnmatched)
+}
+
+func TestServer_MirrorDropsExcess(t *testing.T) {
+	var mirrored atomic.Int32
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	s := newServer([]model.Rule{
+		pathRule(t, "shadowed", "/shadowed", &model.Action{
+			UpstreamHost: upstream.URL,
+			DeliveryMode: model.DeliveryModeInstant,
+			Mirror:       shadow.URL,
+			TimeOut:      1,
+		}),
+	}, nil)
+	s.mirrors = make(chan struct{}, 1)
+	dropped := metrics.MirrorRequests.WithLabelValues("shadowed", metrics.MirrorDropped)
+	before := testutil.ToFloat64(dropped)
+
+	send := func() {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/shadowed", strings.NewReader("event")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	send()
+	send()
+	assert.Equal(t, 1.0, testutil.ToFloat64(dropped)-before, "second copy dropped while the first is in flight")
+
+	// The stuck copy times out and frees its slot.
+	require.Eventually(t, func() bool { return len(s.mirrors) == 0 }, 3*time.Second, 10*time.Millisecond)
+	send()
+	require.Eventually(t, func() bool { return mirrored.Load() == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_ReplayClientSubject(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	a, err := archive.NewJSONL(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer a.Close()
+
+	ts := &model.TriggerSet{}
+	require.NoError(t, yaml.Unmarshal([]byte(`triggers:
+  - property: client_subject
+    comparator: equal
+    value:
+      value: CN=billing,O=Example`), ts))
+	action := &model.Action{UpstreamHost: upstream.URL, DeliveryMode: model.DeliveryModeInstant}
+	s := newServer([]model.Rule{
+		{Name: "billing", TriggerSet: ts, Action: action},
+		pathRule(t, "anyone", "/hook", action),
+	}, nil)
+	s.SetArchive(a)
+
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("event"))
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "billing", Organization: []string{"Example"}}},
+	}}}
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	var e *archive.Entry
+	require.NoError(t, a.Scan(context.Background(), archive.Filter{}, func(entry *archive.Entry) error {
+		e = entry
+		return nil
+	}))
+	require.NotNil(t, e)
+	assert.Equal(t, "billing", e.Rule)
+	assert.Equal(t, "CN=billing,O=Example", e.ClientSubject)
+
+	// The replayed request matches the rule the caller's identity selected.
+	rule, res, err := s.Replay(context.Background(), e, "")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "billing", rule)
+}
@@ -0,0 +1,61 @@
+package server
+
+import "sync"
+
+// RuleStats counts what happened to requests matched by one rule.
+type RuleStats struct {
+	Matched uint64
+	Failed  uint64
+}
+
+// Stats is a snapshot of the server's request counters.
+type Stats struct {
+	Unmatched uint64
+	Rules     map[string]RuleStats
+}
+
+type stats struct {
+	mu        sync.Mutex
+	unmatched uint64
+	rules     map[string]*RuleStats
+}
+
+func (s *stats) rule(name string) *RuleStats {
+	if s.rules == nil {
+		s.rules = make(map[string]*RuleStats)
+	}
+	rs, ok := s.rules[name]
+	if !ok {
+		rs = &RuleStats{}
+		s.rules[name] = rs
+	}
+	return rs
+}
+
+func (s *stats) matched(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rule(name).Matched++
+}
+
+func (s *stats) failed(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rule(name).Failed++
+}
+
+func (s *stats) noMatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unmatched++
+}
+
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := Stats{Unmatched: s.unmatched, Rules: make(map[string]RuleStats, len(s.rules))}
+	for name, rs := range s.rules {
+		out.Rules[name] = *rs
+	}
+	return out
+}
@@ -0,0 +1,223 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// ClientAuthRequire rejects connections without a certificate signed by
+	// the client CA bundle.
+	ClientAuthRequire = "require"
+	// ClientAuthVerifyIfGiven accepts connections without a certificate, but
+	// verifies any that is presented.
+	ClientAuthVerifyIfGiven = "verify_if_given"
+)
+
+var (
+	ErrNoCertificate     = errors.New("certificate and key files not configured")
+	ErrInvalidVersion    = errors.New("unsupported TLS version")
+	ErrInvalidCipher     = errors.New("unsupported cipher suite")
+	ErrInvalidClientAuth = errors.New("unsupported client auth")
+)
+
+// Options configure TLS termination. MinVersion defaults to 1.2, and
+// CipherSuites to Go's defaults; they do not apply to TLS 1.3. Client
+// certificates are verified against ClientCAFile when set, and required
+// unless ClientAuth is verify_if_given.
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   string
+	CipherSuites []string
+	ClientCAFile string
+	ClientAuth   string
+}
+
+// ParseVersion parses a TLS version such as "1.2".
+func ParseVersion(s string) (uint16, error) {
+	switch s {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("%w %q", ErrInvalidVersion, s)
+}
+
+// ParseCipherSuite parses the name of a cipher suite Go considers secure,
+// e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+func ParseCipherSuite(name string) (uint16, error) {
+	for _, c := range tls.CipherSuites() {
+		if c.Name == name {
+			return c.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrInvalidCipher, name)
+}
+
+// ParseClientAuth parses how client certificates are verified against a CA
+// bundle.
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	case ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven, nil
+	}
+	return 0, fmt.Errorf("%w %q", ErrInvalidClientAuth, s)
+}
+
+// Reloader serves the certificate and client CA bundle read from files,
+// picking up new versions when Reload finds the files changed, e.g. after a
+// certificate was renewed. Connections already established keep the
+// certificate they were accepted with.
+type Reloader struct {
+	opts Options
+	base *tls.Config
+
+	mu      sync.RWMutex
+	current *tls.Config
+	stamps  []stamp
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// New reads the files of opts, failing if any is invalid.
+func New(opts Options) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, ErrNoCertificate
+	}
+	base := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	var err error
+	if base.MinVersion, err = ParseVersion(opts.MinVersion); err != nil {
+		return nil, err
+	}
+	for _, name := range opts.CipherSuites {
+		id, err := ParseCipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		base.CipherSuites = append(base.CipherSuites, id)
+	}
+	if opts.ClientCAFile != "" {
+		if base.ClientAuth, err = ParseClientAuth(opts.ClientAuth); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Reloader{opts: opts, base: base}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the config to serve with, which resolves the current
+// files on every handshake. GetCertificate is only there so that
+// http.Server.ServeTLS does not require certificate files of its own.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         r.base.MinVersion,
+		NextProtos:         r.base.NextProtos,
+		GetConfigForClient: r.configForClient,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.config().Certificates[0], nil
+		},
+	}
+}
+
+func (r *Reloader) configForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.config(), nil
+}
+
+func (r *Reloader) config() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Reload reads the files again if any of them changed since they were last
+// read, and reports whether it did. On error the current files keep serving.
+func (r *Reloader) Reload() (bool, error) {
+	paths := r.paths()
+	stamps := make([]stamp, len(paths))
+	for i, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		stamps[i] = stamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	r.mu.RLock()
+	unchanged := r.current != nil && equalStamps(stamps, r.stamps)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	config, err := r.load()
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	r.current, r.stamps = config, stamps
+	r.mu.Unlock()
+	return true, nil
+}
+
+func (r *Reloader) paths() []string {
+	paths := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		paths = append(paths, r.opts.ClientCAFile)
+	}
+	return paths
+}
+
+func (r *Reloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	config := r.base.Clone()
+	config.Certificates = []tls.Certificate{cert}
+
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA bundle %s", r.opts.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+func equalStamps(a, b []stamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// newCert issues a certificate for cn, signed by parent or self-signed when
+// parent is nil.
+func newCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+// serve starts an HTTPS server with r that responds with the subject of the
+// verified client certificate.
+func serve(t *testing.T, r *Reloader) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) > 0 {
+			w.Write([]byte(req.TLS.VerifiedChains[0][0].Subject.String()))
+		}
+	}))
+	srv.TLS = r.TLSConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func client(roots *x509.CertPool, certs ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+}
+
+func get(c *http.Client, url string) (string, *x509.Certificate, error) {
+	resp, err := c.Get(url)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 256)
+	n, _ := resp.Body.Read(buf)
+	return string(buf[:n]), resp.TLS.PeerCertificates[0], nil
+}
+
+func TestReloader_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil)
+	certFile, keyFile := newCert(t, "server", ca).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name        string
+		clientAuth  string
+		certs       []tls.Certificate
+		wantSubject string
+		wantErr     bool
+	}{
+		{name: "verified client", certs: []tls.Certificate{newCert(t, "billing", ca).tls}, wantSubject: "CN=billing,O=Example"},
+		{name: "missing client certificate", wantErr: true},
+		{name: "untrusted client certificate", certs: []tls.Certificate{newCert(t, "billing", nil).tls}, wantErr: true},
+		{name: "optional client certificate", clientAuth: ClientAuthVerifyIfGiven},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: tt.clientAuth})
+			require.NoError(t, err)
+			srv := serve(t, r)
+
+			subject, _, err := get(client(roots, tt.certs...), srv.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSubject, subject)
+		})
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil)
+	first := newCert(t, "first", ca)
+	certFile, keyFile := first.write(t, dir, "server")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	r, err := New(Options{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"})
+	require.NoError(t, err)
+	srv := serve(t, r)
+
+	reloaded, err := r.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	_, cert, err := get(client(roots), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "first", cert.Subject.CommonName)
+
+	// A broken key keeps the current certificate serving.
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0600))
+	_, err = r.Reload()
+	assert.Error(t, err)
+
+	newCert(t, "second", ca).write(t, dir, "server")
+	reloaded, err = r.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+
+	// New connections are served the renewed certificate.
+	_, cert, err = get(client(roots), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "second", cert.Subject.CommonName)
+
+	_, _, err = get(&http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12}}}, srv.URL)
+	assert.Error(t, err, "connections below the minimum version are refused")
+}
+
+func TestNew_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newCert(t, "server", nil).write(t, dir, "server")
+	emptyCA := filepath.Join(dir, "empty.crt")
+	require.NoError(t, os.WriteFile(emptyCA, nil, 0600))
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr error
+	}{
+		{name: "no certificate", opts: Options{}, wantErr: ErrNoCertificate},
+		{name: "unknown version", opts: Options{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.4"}, wantErr: ErrInvalidVersion},
+		{name: "insecure cipher", opts: Options{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, wantErr: ErrInvalidCipher},
+		{name: "unknown client auth", opts: Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: emptyCA, ClientAuth: "maybe"}, wantErr: ErrInvalidClientAuth},
+		{name: "empty CA bundle", opts: Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: emptyCA}},
+		{name: "missing key", opts: Options{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.opts)
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err := New(Options{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}})
+	assert.NoError(t, err)
+}